@@ -0,0 +1,95 @@
+package main
+
+import context "context"
+import sync "sync"
+import atomic "sync/atomic"
+
+// sampleStats are the running statistics of all samples taken for a pixel.
+type sampleStats struct {
+	n    int32
+	mean Vec3
+	m2   float32 // sum of squared deviations from the mean luminance
+}
+
+// merge adds a batch of n samples with the given mean and m2 to st, using
+// the parallel variant of Welford's algorithm.
+func (st *sampleStats) merge(n int32, mean Vec3, m2 float32) {
+	total := float32(st.n + n)
+	d := luminance(mean) - luminance(st.mean)
+	st.m2 += m2 + d*d*float32(st.n)*float32(n)/total
+	st.mean = vec3add(vec3mulf(st.mean, float32(st.n)/total), vec3mulf(mean, float32(n)/total))
+	st.n += n
+}
+
+// variance returns the estimated variance of the mean luminance, which
+// shrinks as samples are added. With a single sample it can't be estimated
+// and is infinite.
+func (st *sampleStats) variance() float32 {
+	if st.n < 2 {
+		return infinity
+	}
+	return st.m2 / float32(st.n-1) / float32(st.n)
+}
+
+func luminance(c Vec3) float32 {
+	return 0.2126*c.x + 0.7152*c.y + 0.0722*c.z
+}
+
+// renderAdaptive renders the initial pass, and then refines the image until
+// no pixel needs more samples, AdaptivePasses are done or ctx is cancelled.
+func (ren *Renderer) renderAdaptive(ctx context.Context) error {
+	ren.accum = make([]sampleStats, ren.xres*ren.yres)
+	var wg sync.WaitGroup
+	err := ren.dispatch(ctx, 0, ren.yres, 0, &wg)
+	for pass := 1; pass <= ren.AdaptivePasses && err == nil; pass++ {
+		// Each pass needs to see the complete results of the previous one.
+		wg.Wait()
+		if pass > 1 && atomic.LoadInt64(&ren.refined) == 0 {
+			break
+		}
+		atomic.StoreInt64(&ren.refined, 0)
+		err = ren.dispatch(ctx, 0, ren.yres, pass, &wg)
+	}
+	wg.Wait()
+
+	ren.VarianceBuffer = make([]float32, len(ren.accum))
+	ren.stats.pixelCounts = make([]int32, len(ren.accum))
+	for i := range ren.accum {
+		ren.VarianceBuffer[i] = ren.accum[i].variance()
+		ren.stats.pixelCounts[i] = ren.accum[i].n
+	}
+	ren.stats.variance = ren.VarianceBuffer
+	ren.accum = nil
+	return err
+}
+
+// refineRect adds jittered samples to all pixels of r whose variance is
+// above the threshold.
+func (ren *Renderer) refineRect(r *Rect, pass int) {
+	ray := Ray{orig: ren.cam.eye}
+	rng := ren.tileRand(r.l, r.t, pass)
+	var samples, refined int64
+	for y := r.t; y < r.b; y++ {
+		cy := ren.cam.h - (y + 1)
+		for x := r.l; x < r.r; x++ {
+			st := &ren.accum[y*ren.xres+x]
+			if ren.MaxSamples > 0 && int(st.n) >= ren.MaxSamples || st.variance() <= ren.VarianceThreshold {
+				continue
+			}
+			p := ren.renderPixel(&ray, x, cy, AAJitter, rng, nil)
+			wasFinite := isFinite(st.mean)
+			st.merge(int32(ren.ss*ren.ss), p.color, p.m2)
+			ren.t.SetV(x, y, gammaEncode(st.mean, ren.Gamma))
+			if ren.hdr != nil {
+				ren.hdr.Set(x, y, st.mean)
+			}
+			if wasFinite {
+				ren.checkFinite(x, y, st.mean)
+			}
+			samples += int64(ren.ss * ren.ss)
+			refined++
+		}
+	}
+	atomic.AddInt64(&ren.stats.samples, samples)
+	atomic.AddInt64(&ren.refined, refined)
+}
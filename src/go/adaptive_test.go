@@ -0,0 +1,44 @@
+package main
+
+import testing "testing"
+
+func TestAdaptiveSamplingRefinesEdges(t *testing.T) {
+	ren := pyramidRenderer(64, 48, 3, 2)
+	ren.depth = make([]float32, 64*48)
+	ren.VarianceThreshold = 1e-5
+	ren.AdaptivePasses = 3
+	mustRender(t, ren)
+	// Silhouettes are where the nearest hit jumps between neighbours.
+	edge := func(x, y int) bool {
+		d := ren.depth[y*64+x]
+		for _, n := range [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+			if (Rect{0, 0, 64, 48}).Contains(n[0], n[1]) && (ren.depth[n[1]*64+n[0]] == infinity) != (d == infinity) {
+				return true
+			}
+		}
+		return false
+	}
+	var edgeSamples, flatSamples, edges, flats int
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			n := int(ren.stats.pixelCounts[y*64+x])
+			if edge(x, y) {
+				edgeSamples += n
+				edges++
+			} else if ren.depth[y*64+x] == infinity {
+				flatSamples += n
+				flats++
+			}
+		}
+	}
+	if edges == 0 || flats == 0 {
+		t.Fatalf("found %d edge and %d background pixels", edges, flats)
+	}
+	edgeAvg, flatAvg := float64(edgeSamples)/float64(edges), float64(flatSamples)/float64(flats)
+	if flatAvg != 4 {
+		t.Errorf("background pixels got %g samples on average, want only the initial 4", flatAvg)
+	}
+	if edgeAvg <= 2*flatAvg {
+		t.Errorf("edge pixels got %g samples on average, want more than twice the %g of the background", edgeAvg, flatAvg)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import fmt "fmt"
+import io "io"
+import math "math"
+
+// SceneStats describes the geometry of a scene.
+type SceneStats struct {
+	SphereCount, OtherCount   int // leaf primitives
+	GroupCount, LODCount      int
+	LightCount                int
+	MaxGroupDepth             int     // of the deepest nesting of groups, 0 if there are none
+	TotalBoundingSphereVolume float32 // of all group bounds, summed
+}
+
+// Analyze counts the geometry of s, without rendering it. Only the most
+// detailed level of LOD nodes is counted, as that's what close-ups trace.
+func (s *Scene) Analyze() SceneStats {
+	st := SceneStats{LightCount: 1}
+	st.add(s.g, 0)
+	return st
+}
+
+func (st *SceneStats) add(g Geometry, depth int) {
+	switch g := g.(type) {
+	case *Sphere:
+		st.SphereCount++
+	case *Group:
+		st.GroupCount++
+		st.MaxGroupDepth = max(st.MaxGroupDepth, depth+1)
+		r := float64(g.bound.radius)
+		st.TotalBoundingSphereVolume += float32(4 * math.Pi / 3 * r * r * r)
+		for _, c := range g.children {
+			st.add(c, depth+1)
+		}
+	case *LODGeometry:
+		st.LODCount++
+		st.add(g.levels[0].geo, depth)
+	default:
+		st.OtherCount++
+	}
+}
+
+func (st *SceneStats) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d spheres, %d other primitives, %d lights\n", st.SphereCount, st.OtherCount, st.LightCount)
+	fmt.Fprintf(w, "%d groups nested %d deep, with bounds of %g total volume\n", st.GroupCount, st.MaxGroupDepth, st.TotalBoundingSphereVolume)
+	if st.LODCount > 0 {
+		fmt.Fprintf(w, "%d LOD nodes\n", st.LODCount)
+	}
+}
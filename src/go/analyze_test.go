@@ -0,0 +1,29 @@
+package main
+
+import math "math"
+import testing "testing"
+
+func TestAnalyzePyramid(t *testing.T) {
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, createSpherePyramid(4, Vec3{0.0, -1.0, 0.0}, 1.0), SolidBackground(backgroundColor))
+	st := scene.Analyze()
+	// Each level has a sphere and 4 pyramids of the level below.
+	want := SceneStats{SphereCount: 85, GroupCount: 21, LightCount: 1, MaxGroupDepth: 3}
+	if st.SphereCount != want.SphereCount || st.OtherCount != 0 || st.GroupCount != want.GroupCount || st.LODCount != 0 || st.LightCount != want.LightCount || st.MaxGroupDepth != want.MaxGroupDepth {
+		t.Errorf("Analyze() = %+v, want %+v", st, want)
+	}
+	// The bounds have radii of 3, 4 of 1.5 and 16 of 0.75.
+	if v := 4 * math.Pi / 3 * (27 + 4*3.375 + 16*0.421875); !approx(st.TotalBoundingSphereVolume, float32(v), 1e-3) {
+		t.Errorf("bound volume is %g, want %g", st.TotalBoundingSphereVolume, v)
+	}
+}
+
+func TestAnalyzeCountsOnlyFinestLOD(t *testing.T) {
+	cam := NewCamera(Vec3{0, 0, -4}, 40, 30)
+	fine := createSpherePyramid(3, Vec3{}, 1)
+	lod := NewLOD(cam, Sphere{Vec3{}, 3}, LODLevel{50, fine}, LODLevel{0, &Sphere{Vec3{}, 1}})
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, NewGroup(Sphere{Vec3{}, 10}, []Geometry{lod, lens()}), SolidBackground(backgroundColor))
+	st := scene.Analyze()
+	if st.SphereCount != 21 || st.OtherCount != 1 || st.LODCount != 1 || st.GroupCount != 6 || st.MaxGroupDepth != 3 {
+		t.Errorf("Analyze() = %+v, want 21 spheres, 1 other, 1 LOD, 6 groups 3 deep", st)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import errors "errors"
+
+// SceneBuilder assembles a renderable scene step by step, as in
+//
+//	ren, err := NewSceneBuilder().AddSphere(Vec3{0, 0, 0}, 1).AddDirectionalLight(Vec3{-1, -3, 2}).Build()
+//
+// Errors are reported by Build, so calls can be chained freely. The tracer
+// has a single diffuse material, white directional light and a camera looking
+// along +z, so there is no WithMaterial, lights have no color, and the
+// camera takes neither a target nor a field of view.
+type SceneBuilder struct {
+	spheres    []*Sphere
+	lights     []Vec3
+	eye        Vec3
+	w, h       int
+	ss         int
+	background func(*Ray) Vec3
+}
+
+func NewSceneBuilder() *SceneBuilder {
+	return &SceneBuilder{eye: Vec3{0, 0, -4.0}, w: 1024, h: 768, ss: 4, background: SolidBackground(backgroundColor)}
+}
+
+func (b *SceneBuilder) AddSphere(center Vec3, radius float32) *SceneBuilder {
+	b.spheres = append(b.spheres, &Sphere{center, radius})
+	return b
+}
+
+// AddDirectionalLight adds a light shining along dir, which doesn't need to
+// be normalized.
+func (b *SceneBuilder) AddDirectionalLight(dir Vec3) *SceneBuilder {
+	b.lights = append(b.lights, dir)
+	return b
+}
+
+// SetCamera places the camera at eye, looking along +z.
+func (b *SceneBuilder) SetCamera(eye Vec3) *SceneBuilder {
+	b.eye = eye
+	return b
+}
+
+func (b *SceneBuilder) SetResolution(w, h int) *SceneBuilder {
+	b.w, b.h = w, h
+	return b
+}
+
+// SetBackground sets the color of rays which hit nothing, as returned by
+// SolidBackground or GradientBackground.
+func (b *SceneBuilder) SetBackground(bg func(*Ray) Vec3) *SceneBuilder {
+	b.background = bg
+	return b
+}
+
+// SetSuperSampling sets the amount of subsamples along each axis of a pixel.
+func (b *SceneBuilder) SetSuperSampling(ss int) *SceneBuilder {
+	b.ss = ss
+	return b
+}
+
+// Build returns a renderer for the scene, with its own texture to render to.
+func (b *SceneBuilder) Build() (*Renderer, error) {
+	switch {
+	case len(b.spheres) == 0:
+		return nil, errors.New("scene has no geometry")
+	case len(b.lights) == 0:
+		return nil, errors.New("scene has no light")
+	case len(b.lights) > 1:
+		return nil, errors.New("scenes only support a single light")
+	case !isNonZero(b.lights[0]):
+		return nil, errors.New("light direction must not be zero")
+	case b.w <= 0 || b.h <= 0:
+		return nil, errors.New("resolution must be positive")
+	case b.ss <= 0:
+		return nil, errors.New("supersampling must be positive")
+	}
+	var g Geometry = b.spheres[0]
+	if len(b.spheres) > 1 {
+		children := make([]Geometry, len(b.spheres))
+		for i, s := range b.spheres {
+			children[i] = s
+		}
+		g = NewGroup(boundingSphere(b.spheres), children)
+	}
+	scene, err := createScene(b.lights[0], g, b.background)
+	if err != nil {
+		return nil, err
+	}
+	cam := NewCamera(b.eye, b.w, b.h)
+	return NewRenderer(scene, NewTexture(b.w, b.h), cam, b.ss), nil
+}
+
+func isNonZero(v Vec3) bool {
+	_, ok := NormalizeSafe(v)
+	return ok
+}
+
+// boundingSphere returns a sphere around the centroid of spheres which
+// contains all of them.
+func boundingSphere(spheres []*Sphere) Sphere {
+	var c Vec3
+	for _, s := range spheres {
+		c = vec3add(c, s.center)
+	}
+	c = vec3mulf(c, 1/float32(len(spheres)))
+	var r float32
+	for _, s := range spheres {
+		d := vec3sub(s.center, c)
+		if e := sqrtf(vec3dot(d, d)) + s.radius; e > r {
+			r = e
+		}
+	}
+	return Sphere{c, r}
+}
@@ -0,0 +1,31 @@
+package main
+
+import context "context"
+import testing "testing"
+
+func TestSceneBuilderMinimalChain(t *testing.T) {
+	ren, err := NewSceneBuilder().AddSphere(Vec3{0, 0, 0}, 1).AddDirectionalLight(Vec3{-1, -3, 2}).SetResolution(32, 24).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ren.Render(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := ren.t.GetRgba(16, 12)
+	if bg := [3]byte{f2b(backgroundColor.x), f2b(backgroundColor.y), f2b(backgroundColor.z)}; [3]byte{r, g, b} == bg {
+		t.Errorf("the center of the image shows the background, not the sphere")
+	}
+}
+
+func TestSceneBuilderRejectsIncompleteScenes(t *testing.T) {
+	for name, b := range map[string]*SceneBuilder{
+		"no geometry": NewSceneBuilder().AddDirectionalLight(Vec3{-1, -3, 2}),
+		"no light":    NewSceneBuilder().AddSphere(Vec3{}, 1),
+		"zero light":  NewSceneBuilder().AddSphere(Vec3{}, 1).AddDirectionalLight(Vec3{}),
+		"two lights":  NewSceneBuilder().AddSphere(Vec3{}, 1).AddDirectionalLight(Vec3{0, -1, 0}).AddDirectionalLight(Vec3{1, 0, 0}),
+	} {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("%s: Build succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import math "math"
+
+// gammaEncode returns v with its positive components raised to 1/gamma, as
+// displays expect. A gamma of 0 or 1 returns v as is.
+func gammaEncode(v Vec3, gamma float32) Vec3 {
+	if gamma == 0 || gamma == 1 {
+		return v
+	}
+	e := 1 / float64(gamma)
+	f := func(c float32) float32 {
+		if c <= 0 {
+			return c
+		}
+		return float32(math.Pow(float64(c), e))
+	}
+	return Vec3{f(v.x), f(v.y), f(v.z)}
+}
+
+// ToHSV returns the hue of the RGB color v in degrees within [0, 360), and
+// its saturation and value within [0, 1] for components within [0, 1].
+// Achromatic colors have a hue and saturation of 0.
+func (v Vec3) ToHSV() (h, s, val float32) {
+	hi := max(v.x, v.y, v.z)
+	lo := min(v.x, v.y, v.z)
+	c := hi - lo
+	val = hi
+	if hi <= 0 || c == 0 {
+		return 0, 0, val
+	}
+	s = c / hi
+	switch hi {
+	case v.x:
+		h = (v.y - v.z) / c
+	case v.y:
+		h = 2 + (v.z-v.x)/c
+	default:
+		h = 4 + (v.x-v.y)/c
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, val
+}
+
+// HSVtoVec3 returns the RGB color for the hue h in degrees, and the
+// saturation s and value v.
+func HSVtoVec3(h, s, v float32) Vec3 {
+	h = float32(math.Mod(float64(h), 360))
+	if h < 0 {
+		h += 360
+	}
+	c := v * s
+	hp := h / 60
+	x := c * (1 - float32(math.Abs(math.Mod(float64(hp), 2)-1)))
+	var rgb Vec3
+	switch {
+	case hp < 1:
+		rgb = Vec3{c, x, 0}
+	case hp < 2:
+		rgb = Vec3{x, c, 0}
+	case hp < 3:
+		rgb = Vec3{0, c, x}
+	case hp < 4:
+		rgb = Vec3{0, x, c}
+	case hp < 5:
+		rgb = Vec3{x, 0, c}
+	default:
+		rgb = Vec3{c, 0, x}
+	}
+	m := v - c
+	return vec3add(rgb, Vec3{m, m, m})
+}
+
+// HueRotate returns c with its hue rotated by degrees, keeping saturation
+// and value. All spheres share a single diffuse color rather than having
+// materials, so tints apply to colors directly.
+func HueRotate(c Vec3, degrees float32) Vec3 {
+	h, s, v := c.ToHSV()
+	return HSVtoVec3(h+degrees, s, v)
+}
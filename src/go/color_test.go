@@ -0,0 +1,47 @@
+package main
+
+import testing "testing"
+
+func TestHSVRoundTripRed(t *testing.T) {
+	red := Vec3{1, 0, 0}
+	h, s, v := red.ToHSV()
+	if h != 0 || s != 1 || v != 1 {
+		t.Errorf("red is h=%g s=%g v=%g, want 0, 1, 1", h, s, v)
+	}
+	if got := HSVtoVec3(h, s, v); got != red {
+		t.Errorf("red came back as %v", got)
+	}
+}
+
+func TestHSVRoundTrip(t *testing.T) {
+	for _, c := range []Vec3{{0, 0.7, 0}, {0.2, 0.3, 0.2}, {0.9, 0.5, 0.1}, {0.1, 0.2, 0.8}, {1, 0, 1}} {
+		h, s, v := c.ToHSV()
+		if got := HSVtoVec3(h, s, v); !approx(got.x, c.x, 1e-6) || !approx(got.y, c.y, 1e-6) || !approx(got.z, c.z, 1e-6) {
+			t.Errorf("%v came back as %v", c, got)
+		}
+	}
+}
+
+func TestHSVAchromatic(t *testing.T) {
+	for _, c := range []Vec3{{0, 0, 0}, {0.5, 0.5, 0.5}, {1, 1, 1}} {
+		h, s, v := c.ToHSV()
+		if h != 0 || s != 0 || v != c.x {
+			t.Errorf("%v is h=%g s=%g v=%g, want 0, 0, %g", c, h, s, v, c.x)
+		}
+		// Without saturation, the hue doesn't matter.
+		for _, hue := range []float32{0, 120, 300} {
+			if got := HSVtoVec3(hue, 0, c.x); got != c {
+				t.Errorf("gray %g at hue %g is %v", c.x, hue, got)
+			}
+		}
+	}
+}
+
+func TestHueRotate(t *testing.T) {
+	if got := HueRotate(Vec3{1, 0, 0}, 120); !approx(got.x, 0, 1e-6) || !approx(got.y, 1, 1e-6) || !approx(got.z, 0, 1e-6) {
+		t.Errorf("red rotated by 120 degrees is %v, want green", got)
+	}
+	if got := HueRotate(Vec3{0, 0, 1}, -120); !approx(got.x, 0, 1e-6) || !approx(got.y, 1, 1e-6) || !approx(got.z, 0, 1e-6) {
+		t.Errorf("blue rotated by -120 degrees is %v, want green", got)
+	}
+}
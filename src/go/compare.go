@@ -0,0 +1,51 @@
+package main
+
+import math "math"
+
+// CompareImages returns whether a and b match within tolerance, and the
+// largest difference of any color channel between them. Differences are in
+// [0, 1], with 1 being the full range of a channel, so a tolerance of 0
+// requires identical colors. Alpha is ignored, and images of different
+// sizes never match, with an infinite difference.
+func CompareImages(a, b *Texture, tolerance float64) (bool, float64) {
+	if a.w != b.w || a.h != b.h {
+		return false, math.Inf(1)
+	}
+	var maxDiff float64
+	for y := 0; y < a.h; y++ {
+		for x := 0; x < a.w; x++ {
+			maxDiff = math.Max(maxDiff, pixelDiff(a, b, x, y))
+		}
+	}
+	return maxDiff <= tolerance, maxDiff
+}
+
+// DiffImage returns an image of a in dimmed gray, with the pixels which
+// differ from b by more than tolerance in red. a and b must have the same
+// size.
+func DiffImage(a, b *Texture, tolerance float64) *Texture {
+	d := NewTexture(a.w, a.h)
+	for y := 0; y < a.h; y++ {
+		for x := 0; x < a.w; x++ {
+			if pixelDiff(a, b, x, y) > tolerance {
+				d.SetRgba(x, y, 255, 0, 0, 255)
+				continue
+			}
+			r, g, bl, _ := a.GetRgba(x, y)
+			l := byte((int(r) + int(g) + int(bl)) / 12)
+			d.SetRgba(x, y, l, l, l, 255)
+		}
+	}
+	return d
+}
+
+// pixelDiff is the largest difference of the color channels of a and b at
+// x, y.
+func pixelDiff(a, b *Texture, x, y int) float64 {
+	o := a.offset(x, y)
+	var d int
+	for c := 0; c < 3; c++ {
+		d = max(d, int(a.buf[o+c])-int(b.buf[o+c]), int(b.buf[o+c])-int(a.buf[o+c]))
+	}
+	return float64(d) / 255
+}
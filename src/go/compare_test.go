@@ -0,0 +1,55 @@
+package main
+
+import math "math"
+import testing "testing"
+
+func TestCompareIdenticalImages(t *testing.T) {
+	a := asymmetricTexture(7, 5)
+	if ok, d := CompareImages(a, a.Copy(), 0); !ok || d != 0 {
+		t.Errorf("identical images compared as %v with a difference of %g", ok, d)
+	}
+	diff := DiffImage(a, a.Copy(), 0)
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			if r, g, _, _ := diff.GetRgba(x, y); r != g {
+				t.Fatalf("pixel %d, %d is marked as different", x, y)
+			}
+		}
+	}
+}
+
+func TestCompareOnePixelDifference(t *testing.T) {
+	a := asymmetricTexture(7, 5)
+	b := a.Copy()
+	r, g, bl, al := b.GetRgba(3, 2)
+	b.SetRgba(3, 2, r, g+51, bl, al)
+	want := 51.0 / 255
+	if ok, d := CompareImages(a, b, 0.1); ok || d != want {
+		t.Errorf("CompareImages with tolerance 0.1 = %v, %g, want false, %g", ok, d, want)
+	}
+	if ok, _ := CompareImages(a, b, want); !ok {
+		t.Errorf("CompareImages with a tolerance of the difference failed")
+	}
+	diff := DiffImage(a, b, 0.1)
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			r, g, bl, _ := diff.GetRgba(x, y)
+			if red := r == 255 && g == 0 && bl == 0; red != (x == 3 && y == 2) {
+				t.Errorf("pixel %d, %d is %d, %d, %d in the diff image", x, y, r, g, bl)
+			}
+		}
+	}
+}
+
+func TestCompareIgnoresAlphaRejectsSizes(t *testing.T) {
+	a := asymmetricTexture(7, 5)
+	b := a.Copy()
+	r, g, bl, _ := b.GetRgba(0, 0)
+	b.SetRgba(0, 0, r, g, bl, 0)
+	if ok, d := CompareImages(a, b, 0); !ok {
+		t.Errorf("a different alpha compared as a difference of %g", d)
+	}
+	if ok, d := CompareImages(a, asymmetricTexture(5, 7), 1); ok || !math.IsInf(d, 1) {
+		t.Errorf("images of different sizes compared as %v, %g", ok, d)
+	}
+}
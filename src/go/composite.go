@@ -0,0 +1,31 @@
+package main
+
+import fmt "fmt"
+
+// Composite returns over laid on top of base with the "over" operator.
+// Like all textures, both hold straight colors, which aren't premultiplied
+// by their alpha, so blending weighs them by it:
+//
+//	a = a_over + a_base*(1-a_over)
+//	c = (c_over*a_over + c_base*a_base*(1-a_over)) / a
+//
+// With premultiplied colors that would be c = c_over + c_base*(1-a_over),
+// but they lose precision in bytes as alpha nears 0. Both textures must
+// have the same size.
+func Composite(base, over *Texture) (*Texture, error) {
+	if base.w != over.w || base.h != over.h {
+		return nil, fmt.Errorf("can't composite a %dx%d image over a %dx%d one", over.w, over.h, base.w, base.h)
+	}
+	t := NewTexture(base.w, base.h)
+	for y := 0; y < t.h; y++ {
+		for x := 0; x < t.w; x++ {
+			_, _, _, ab := base.GetRgba(x, y)
+			_, _, _, ao := over.GetRgba(x, y)
+			fo := float32(ao) / 255
+			fb := float32(ab) / 255 * (1 - fo)
+			c := vec3add(vec3mulf(over.GetV(x, y), fo), vec3mulf(base.GetV(x, y), fb))
+			t.setPremultiplied(x, y, c, fo+fb, 0)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import testing "testing"
+
+func TestComposite(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		base, over [4]byte
+		want       [4]byte
+	}{
+		{"opaque over", [4]byte{10, 20, 30, 255}, [4]byte{200, 100, 50, 255}, [4]byte{200, 100, 50, 255}},
+		{"clear over", [4]byte{10, 20, 30, 255}, [4]byte{200, 100, 50, 0}, [4]byte{10, 20, 30, 255}},
+		{"over clear", [4]byte{10, 20, 30, 0}, [4]byte{200, 100, 50, 255}, [4]byte{200, 100, 50, 255}},
+		{"both clear", [4]byte{10, 20, 30, 0}, [4]byte{200, 100, 50, 0}, [4]byte{0, 0, 0, 0}},
+		{"half over opaque", [4]byte{0, 0, 200, 255}, [4]byte{200, 0, 0, 102}, [4]byte{80, 0, 120, 255}},
+		// 0.4 + 0.6*0.6 = 0.76 alpha, of which 0.4/0.76 is over.
+		{"half over half", [4]byte{0, 0, 210, 153}, [4]byte{210, 0, 0, 102}, [4]byte{111, 0, 99, 194}},
+		// Straight colors keep their precision at low alpha.
+		{"faint over clear", [4]byte{0, 0, 0, 0}, [4]byte{201, 99, 47, 3}, [4]byte{201, 99, 47, 3}},
+	} {
+		base, over := NewTexture(2, 1), NewTexture(2, 1)
+		base.SetRgba(1, 0, c.base[0], c.base[1], c.base[2], c.base[3])
+		over.SetRgba(1, 0, c.over[0], c.over[1], c.over[2], c.over[3])
+		got, err := Composite(base, over)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, g, b, a := got.GetRgba(1, 0)
+		if r != c.want[0] || g != c.want[1] || b != c.want[2] || a != c.want[3] {
+			t.Errorf("%s: got %d, %d, %d, %d, want %v", c.name, r, g, b, a, c.want)
+		}
+	}
+}
+
+func TestCompositeRejectsSizeMismatch(t *testing.T) {
+	if _, err := Composite(NewTexture(4, 3), NewTexture(3, 4)); err == nil {
+		t.Error("compositing textures of different sizes succeeded")
+	}
+}
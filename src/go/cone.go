@@ -0,0 +1,118 @@
+package main
+
+import errors "errors"
+import fmt "fmt"
+import math "math"
+import sort "sort"
+
+// Cone is a solid cone with its tip at apex, opening along axis by halfAngle
+// radians until it is closed by a disk at height along the axis.
+type Cone struct {
+	apex, axis        Vec3
+	halfAngle, height float32
+	cos2              float32 // cos(halfAngle)^2
+}
+
+// NewCone returns a cone for the given apex and axis, which doesn't need to
+// be normalized. halfAngle must be within (0, Pi/2).
+func NewCone(apex, axis Vec3, halfAngle, height float32) (*Cone, error) {
+	a, ok := NormalizeSafe(axis)
+	switch {
+	case !ok:
+		return nil, errors.New("cone axis must not be zero")
+	case halfAngle <= 0 || halfAngle >= math.Pi/2:
+		return nil, fmt.Errorf("cone half angle %g is not between 0 and Pi/2", halfAngle)
+	case height <= 0:
+		return nil, errors.New("cone height must be positive")
+	}
+	cos := float32(math.Cos(float64(halfAngle)))
+	return &Cone{apex, a, halfAngle, height, cos * cos}, nil
+}
+
+// AllHits returns where r enters and leaves the cone, including hits behind
+// the ray origin.
+func (c *Cone) AllHits(r *Ray) []Hit {
+	co := vec3sub(r.orig, c.apex)
+	da := vec3dot(r.dir, c.axis)
+	oa := vec3dot(co, c.axis)
+	var hits []Hit
+
+	// Points p on the infinite double cone satisfy
+	// dot(p-apex, axis)^2 = cos^2 * dot(p-apex, p-apex).
+	a := da*da - c.cos2
+	b := 2 * (da*oa - c.cos2*vec3dot(r.dir, co))
+	cc := oa*oa - c.cos2*vec3dot(co, co)
+	var ts []float32
+	if a > -1e-6 && a < 1e-6 {
+		// The ray is parallel to the surface and crosses it at most once.
+		if b != 0 {
+			ts = append(ts, -cc/b)
+		}
+	} else if disc := b*b - 4*a*cc; disc >= 0 {
+		d := sqrtf(disc)
+		q := -0.5 * (b + d)
+		if b < 0 {
+			q = -0.5 * (b - d)
+		}
+		ts = append(ts, q/a)
+		if q != 0 {
+			ts = append(ts, cc/q)
+		}
+	}
+	for _, t := range ts {
+		// Only keep the nappe along the axis, below the cap.
+		if h := oa + t*da; h >= 0 && h <= c.height {
+			hits = append(hits, Hit{distance: t, pos: c.sideNormal(vec3add(co, vec3mulf(r.dir, t))), geom: c})
+		}
+	}
+
+	if da != 0 {
+		t := (c.height - oa) / da
+		p := vec3sub(vec3add(co, vec3mulf(r.dir, t)), vec3mulf(c.axis, c.height))
+		if vec3dot(p, p) <= c.height*c.height*(1-c.cos2)/c.cos2 {
+			hits = append(hits, Hit{distance: t, pos: c.axis, geom: c})
+		}
+	}
+
+	// The cone is convex, so rays passing through its rim or apex can only
+	// yield duplicates of the entry and exit.
+	if len(hits) < 2 {
+		return nil
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].distance < hits[j].distance })
+	return []Hit{hits[0], hits[len(hits)-1]}
+}
+
+// sideNormal returns the outward normal of the cone's side at v relative to
+// the apex.
+func (c *Cone) sideNormal(v Vec3) Vec3 {
+	n := vec3sub(vec3mulf(v, vec3dot(v, c.axis)), vec3mulf(c.axis, vec3dot(v, v)))
+	if n, ok := NormalizeSafe(n); ok {
+		return n
+	}
+	// At the apex itself.
+	return vec3mulf(c.axis, -1)
+}
+
+func (c *Cone) Intersect(h *Hit, r *Ray) {
+	hits := c.AllHits(r)
+	for i, hit := range hits {
+		if hit.distance <= 0 {
+			continue
+		}
+		if hit.distance >= h.distance {
+			return
+		}
+		*h = hit
+		if i == 1 {
+			// Seen from within, the surface faces the axis.
+			h.inside = true
+			h.pos = vec3mulf(h.pos, -1)
+		}
+		return
+	}
+}
+
+func (c *Cone) Print() {
+	fmt.Println("Cone:", *c)
+}
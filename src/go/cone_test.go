@@ -0,0 +1,51 @@
+package main
+
+import math "math"
+import testing "testing"
+
+// lampShade returns a cone with its apex at the origin, opening downwards
+// by 30 degrees to a base 2 below it.
+func lampShade(t *testing.T) *Cone {
+	t.Helper()
+	c, err := NewCone(Vec3{}, Vec3{0, -1, 0}, math.Pi/6, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestConeMissesAboveApex(t *testing.T) {
+	r := Ray{Vec3{0, 0.5, -5}, Vec3{0, 0, 1}}
+	h := hitinfinity
+	lampShade(t).Intersect(&h, &r)
+	if h.distance != infinity {
+		t.Errorf("ray above the apex hit at %g", h.distance)
+	}
+}
+
+func TestConeHitFromSide(t *testing.T) {
+	r := Ray{Vec3{-5, -1, 0}, Vec3{1, 0, 0}}
+	h := hitinfinity
+	lampShade(t).Intersect(&h, &r)
+	// One below the apex, the cone's radius is tan(30 degrees).
+	want := 5 - float32(math.Tan(math.Pi/6))
+	if !approx(h.distance, want, 1e-5) {
+		t.Fatalf("hit at %g, want %g", h.distance, want)
+	}
+	if h.inside || vec3dot(h.pos, r.dir) >= 0 {
+		t.Errorf("hit %+v doesn't face the ray from outside", h)
+	}
+	// The side normal tilts away from the axis, here upwards.
+	if h.pos.y <= 0 {
+		t.Errorf("side normal %v doesn't tilt up", h.pos)
+	}
+}
+
+func TestConeHitOnBase(t *testing.T) {
+	r := Ray{Vec3{0.2, -5, 0}, Vec3{0, 1, 0}}
+	h := hitinfinity
+	lampShade(t).Intersect(&h, &r)
+	if !approx(h.distance, 3, 1e-5) || h.pos != (Vec3{0, -1, 0}) {
+		t.Errorf("hit %+v, want the base 3 away facing down", h)
+	}
+}
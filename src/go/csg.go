@@ -0,0 +1,69 @@
+package main
+
+import fmt "fmt"
+
+// CSGIntersection is the solid contained in both a and b.
+type CSGIntersection struct {
+	a, b MultiHitGeometry
+}
+
+func NewCSGIntersection(a, b MultiHitGeometry) *CSGIntersection {
+	return &CSGIntersection{a, b}
+}
+
+// AllHits returns the boundaries of the intervals along r which are inside
+// of both a and b.
+func (c *CSGIntersection) AllHits(r *Ray) []Hit {
+	ah := c.a.AllHits(r)
+	if len(ah) == 0 {
+		return nil
+	}
+	bh := c.b.AllHits(r)
+	var hits []Hit
+	for i, j := 0, 0; i+1 < len(ah) && j+1 < len(bh); {
+		// The overlap of two intervals starts at the later entry and ends
+		// at the earlier exit.
+		entry, exit := ah[i], ah[i+1]
+		if bh[j].distance > entry.distance {
+			entry = bh[j]
+		}
+		if bh[j+1].distance < exit.distance {
+			exit = bh[j+1]
+		}
+		if entry.distance <= exit.distance {
+			hits = append(hits, entry, exit)
+		}
+		// Continue with the interval which ends first.
+		if ah[i+1].distance < bh[j+1].distance {
+			i += 2
+		} else {
+			j += 2
+		}
+	}
+	return hits
+}
+
+func (c *CSGIntersection) Intersect(h *Hit, r *Ray) {
+	for i, hit := range c.AllHits(r) {
+		if hit.distance <= 0 {
+			continue
+		}
+		if hit.distance < h.distance {
+			*h = hit
+			if i%2 == 1 {
+				// The ray starts inside, so it hits an exit, which faces
+				// into the solid when seen from within.
+				h.inside = true
+				h.pos = vec3mulf(h.pos, -1)
+			}
+		}
+		return
+	}
+}
+
+func (c *CSGIntersection) Print() {
+	fmt.Print("CSGIntersection:")
+	c.a.Print()
+	fmt.Print("  ")
+	c.b.Print()
+}
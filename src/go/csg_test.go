@@ -0,0 +1,52 @@
+package main
+
+import testing "testing"
+
+// lens returns the intersection of two unit spheres 1 apart along x.
+func lens() *CSGIntersection {
+	return NewCSGIntersection(&Sphere{Vec3{-0.5, 0, 0}, 1}, &Sphere{Vec3{0.5, 0, 0}, 1})
+}
+
+func TestCSGIntersectionAllHits(t *testing.T) {
+	r := Ray{Vec3{-5, 0, 0}, Vec3{1, 0, 0}}
+	hits := lens().AllHits(&r)
+	if len(hits) != 2 {
+		t.Fatalf("got %d hits, want 2", len(hits))
+	}
+	// The lens spans from the left edge of the right sphere to the right
+	// edge of the left one.
+	if d := hits[0].distance; !approx(d, 4.5, 1e-5) {
+		t.Errorf("entry at %g, want 4.5", d)
+	}
+	if d := hits[1].distance; !approx(d, 5.5, 1e-5) {
+		t.Errorf("exit at %g, want 5.5", d)
+	}
+	miss := Ray{Vec3{-5, 0.95, 0}, Vec3{1, 0, 0}}
+	if hits := lens().AllHits(&miss); len(hits) != 0 {
+		t.Errorf("ray through only one sphere hit the lens at %v", hits)
+	}
+}
+
+func TestCSGIntersectionFromOutside(t *testing.T) {
+	r := Ray{Vec3{-5, 0, 0}, Vec3{1, 0, 0}}
+	h := hitinfinity
+	lens().Intersect(&h, &r)
+	if h.inside || !approx(h.distance, 4.5, 1e-5) || h.pos.x >= 0 {
+		t.Errorf("hit %+v, want the entry at 4.5 facing the ray", h)
+	}
+}
+
+func TestCSGIntersectionFromInside(t *testing.T) {
+	r := Ray{Vec3{0, 0, 0}, Vec3{1, 0, 0}}
+	h := hitinfinity
+	lens().Intersect(&h, &r)
+	if !h.inside {
+		t.Errorf("hit from inside the lens doesn't have inside set")
+	}
+	if !approx(h.distance, 0.5, 1e-5) {
+		t.Errorf("hit at %g, want the exit at 0.5", h.distance)
+	}
+	if vec3dot(h.pos, r.dir) >= 0 {
+		t.Errorf("normal %v doesn't face the ray origin", h.pos)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build debug
+
+package main
+
+// Build with -tags debug to enable consistency checks which are too costly
+// for normal renders.
+const debug = true
@@ -0,0 +1,127 @@
+package main
+
+import math "math"
+
+// How quickly neighbours lose influence as their guide buffers differ from
+// the center pixel's, as the standard deviation in byte units.
+const denoiseAlbedoSigma, denoiseNormalSigma = 8.0, 16.0
+
+// Denoise smooths beauty with a cross-bilateral filter of the given radius.
+// Neighbouring pixels only contribute if their albedo and normal are similar
+// to those of the pixel being filtered, which keeps silhouettes and texture
+// edges sharp while flat regions are averaged. All textures must have the
+// same size.
+func Denoise(beauty, albedo, normal *Texture, radius int) *Texture {
+	out := NewTexture(beauty.w, beauty.h)
+	sigmaS := math.Max(float64(radius)/2, 0.5)
+	spatial := make([]float64, 2*radius+1)
+	for i := range spatial {
+		d := float64(i - radius)
+		spatial[i] = math.Exp(-d * d / (2 * sigmaS * sigmaS))
+	}
+	for y := 0; y < beauty.h; y++ {
+		for x := 0; x < beauty.w; x++ {
+			o := 4 * (beauty.w*y + x)
+			var r, g, b, wsum float64
+			for dy := -radius; dy <= radius; dy++ {
+				ny := y + dy
+				if ny < 0 || ny >= beauty.h {
+					continue
+				}
+				for dx := -radius; dx <= radius; dx++ {
+					nx := x + dx
+					if nx < 0 || nx >= beauty.w {
+						continue
+					}
+					no := 4 * (beauty.w*ny + nx)
+					wt := spatial[dx+radius] * spatial[dy+radius] *
+						rangeWeight(albedo.buf, o, no, denoiseAlbedoSigma) *
+						rangeWeight(normal.buf, o, no, denoiseNormalSigma)
+					r += wt * float64(beauty.buf[no])
+					g += wt * float64(beauty.buf[no+1])
+					b += wt * float64(beauty.buf[no+2])
+					wsum += wt
+				}
+			}
+			n := 1 / (255 * wsum)
+			out.buf[o] = f2b(float32(r * n))
+			out.buf[o+1] = f2b(float32(g * n))
+			out.buf[o+2] = f2b(float32(b * n))
+			out.buf[o+3] = beauty.buf[o+3]
+		}
+	}
+	return out
+}
+
+// rangeWeight is the gaussian weight of the color difference between the
+// pixels at offsets a and b in buf.
+func rangeWeight(buf []byte, a, b int, sigma float64) float64 {
+	var d2 float64
+	for c := 0; c < 3; c++ {
+		d := float64(buf[a+c]) - float64(buf[b+c])
+		d2 += d * d
+	}
+	return math.Exp(-d2 / (2 * sigma * sigma))
+}
+
+// JointBilateral returns a post stage which smooths an image guided by the
+// renderer's normal and depth buffers, so the blur stops at creases and
+// silhouettes. sigmaS is the spatial standard deviation in pixels, sigmaN
+// the one of normal differences, and sigmaD the one of depth differences
+// relative to the depth of the pixel being filtered.
+func JointBilateral(ren *Renderer, sigmaS, sigmaN, sigmaD float32, workers int) PostStage {
+	k := gaussianKernel(sigmaS)
+	r := len(k) / 2
+	return func(im *FloatImage) error {
+		normals := make([]Vec3, len(im.pix))
+		for i := range normals {
+			// Undo the mapping of normals into the texture's [0, 1].
+			normals[i] = vec3sub(vec3mulf(ren.normal.GetV(i%im.w, i/im.w), 2), Vec3{1, 1, 1})
+		}
+		src := append([]Vec3(nil), im.pix...)
+		parallelRows(im.h, workers, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := 0; x < im.w; x++ {
+					c := y*im.w + x
+					var sum Vec3
+					var wsum float32
+					for dy := -r; dy <= r; dy++ {
+						ny := y + dy
+						if ny < 0 || ny >= im.h {
+							continue
+						}
+						for dx := -r; dx <= r; dx++ {
+							nx := x + dx
+							if nx < 0 || nx >= im.w {
+								continue
+							}
+							n := ny*im.w + nx
+							w := k[dx+r] * k[dy+r] * guideWeight(normals[c], normals[n], ren.depth[c], ren.depth[n], sigmaN, sigmaD)
+							sum = vec3add(sum, vec3mulf(src[n], w))
+							wsum += w
+						}
+					}
+					// The center pixel has a weight, so wsum isn't 0.
+					im.pix[c] = vec3mulf(sum, 1/wsum)
+				}
+			}
+		})
+		return nil
+	}
+}
+
+// guideWeight is the gaussian weight of the normal and relative depth
+// differences between two pixels, which is 0 across silhouettes against the
+// background.
+func guideWeight(n0, n1 Vec3, d0, d1, sigmaN, sigmaD float32) float32 {
+	if (d0 == infinity) != (d1 == infinity) {
+		return 0
+	}
+	dn := vec3sub(n0, n1)
+	e := vec3dot(dn, dn) / (2 * sigmaN * sigmaN)
+	if d0 != infinity {
+		dd := (d1 - d0) / (d0 * sigmaD)
+		e += dd * dd / 2
+	}
+	return float32(math.Exp(float64(-e)))
+}
@@ -0,0 +1,103 @@
+package main
+
+import rand "math/rand"
+import testing "testing"
+
+// silhouette returns beauty, albedo and normal buffers of a noisy flat
+// background on the left half, and an evenly lit sphere on the right half.
+func silhouette(w, h int) (beauty, albedo, normal *Texture) {
+	beauty, albedo, normal = NewTexture(w, h), NewTexture(w, h), NewTexture(w, h)
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				v := byte(80 + rng.Intn(41))
+				beauty.SetRgba(x, y, v, v, v, 255)
+				albedo.SetRgba(x, y, 25, 25, 25, 255)
+				normal.SetRgba(x, y, 0, 0, 0, 255)
+			} else {
+				beauty.SetRgba(x, y, 0, 200, 0, 255)
+				albedo.SetRgba(x, y, 0, 179, 0, 255)
+				normal.SetRgba(x, y, 128, 128, 0, 255)
+			}
+		}
+	}
+	return beauty, albedo, normal
+}
+
+// greenVariance returns the variance of the green channel of t inside r.
+func greenVariance(t *Texture, r Rect) float64 {
+	var sum, sum2 float64
+	r.Each(func(x, y int) {
+		_, g, _, _ := t.GetRgba(x, y)
+		sum += float64(g)
+		sum2 += float64(g) * float64(g)
+	})
+	n := float64(r.Area())
+	return sum2/n - sum*sum/(n*n)
+}
+
+func TestDenoiseSmoothsFlatRegionsKeepsEdges(t *testing.T) {
+	beauty, albedo, normal := silhouette(32, 16)
+	out := Denoise(beauty, albedo, normal, 3)
+	flat := Rect{3, 3, 13, 13}
+	if before, after := greenVariance(beauty, flat), greenVariance(out, flat); after > before/4 {
+		t.Errorf("variance of the flat region went from %g to %g, want it quartered", before, after)
+	}
+	for y := 0; y < 16; y++ {
+		_, bg, _, _ := out.GetRgba(15, y)
+		_, fg, _, _ := out.GetRgba(16, y)
+		if bg > 125 || fg != 200 {
+			t.Errorf("edge in row %d blurred to %d and %d", y, bg, fg)
+		}
+	}
+}
+
+func TestJointBilateralStopsAtSilhouettes(t *testing.T) {
+	const w, h = 32, 16
+	// A white background on the left and a noisy dark object facing the
+	// camera on the right.
+	im := NewFloatImage(w, h)
+	ren := &Renderer{normal: NewTexture(w, h), depth: make([]float32, w*h)}
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			if x < w/2 {
+				im.pix[i] = Vec3{1, 1, 1}
+				ren.depth[i] = infinity
+			} else {
+				v := 0.2 + 0.1*rng.Float32()
+				im.pix[i] = Vec3{v, v, v}
+				ren.depth[i] = 5
+				ren.normal.SetV(x, y, Vec3{0.5, 0.5, 0})
+			}
+		}
+	}
+	variance := func(im *FloatImage, r Rect) float64 {
+		var sum, sum2 float64
+		r.Each(func(x, y int) {
+			v := float64(im.At(x, y).x)
+			sum += v
+			sum2 += v * v
+		})
+		n := float64(r.Area())
+		return sum2/n - sum*sum/(n*n)
+	}
+	interior := Rect{w/2 + 3, 3, w - 3, h - 3}
+	before := variance(im, interior)
+	if err := JointBilateral(ren, 2, 0.1, 0.1, 2)(im); err != nil {
+		t.Fatal(err)
+	}
+	if after := variance(im, interior); after > before/4 {
+		t.Errorf("variance inside the object went from %g to %g, want it quartered", before, after)
+	}
+	for y := 0; y < h; y++ {
+		if bg := im.At(w/2-1, y).x; bg < 1-1e-6 {
+			t.Errorf("background next to the silhouette in row %d darkened to %g", y, bg)
+		}
+		if fg := im.At(w/2, y).x; fg < 0.2 || fg > 0.3 {
+			t.Errorf("object at the silhouette in row %d is %g, outside of its colors", y, fg)
+		}
+	}
+}
@@ -4,10 +4,22 @@
 
 package main
 
+import context "context"
+import errors "errors"
+import flag "flag"
 import fmt "fmt"
+import fnv "hash/fnv"
 import io "io"
 import os "os"
+import runtime "runtime"
+import slices "slices"
+import sort "sort"
+import strings "strings"
+import sync "sync"
+import atomic "sync/atomic"
+import time "time"
 import math "math"
+import rand "math/rand"
 
 var infinity float32 = float32(math.Inf(1))
 var delta float32 = float32(math.Sqrt(1.19209E-07)) // sqrt(float_epsilon)
@@ -16,6 +28,8 @@ func sqrtf(a float32) float32 {
 	return float32(math.Sqrt(float64(a)))
 }
 
+// Vec3 has in-place pointer methods, used to set up primary rays, and the
+// value based vec3* functions, which all intersection and shading code uses.
 type Vec3 struct {
 	x, y, z float32
 }
@@ -78,10 +92,31 @@ func vec3dot(a Vec3, b Vec3) float32 {
 	return a.x*b.x + a.y*b.y + a.z*b.z
 }
 
+func vec3cross(a Vec3, b Vec3) Vec3 {
+	return Vec3{a.y*b.z - a.z*b.y, a.z*b.x - a.x*b.z, a.x*b.y - a.y*b.x}
+}
+
+// normalize returns a scaled to unit length. It doesn't check for zero
+// vectors, which yield NaNs, and is meant for the render's inner loops.
 func normalize(a Vec3) Vec3 {
 	return vec3mulf(a, 1.0/sqrtf(vec3dot(a, a)))
 }
 
+// NormalizeSafe returns a scaled to unit length, or the zero vector and false
+// if a has no finite, non-zero length. Use it for vectors from the outside,
+// like light directions and camera setups. Scaling by the largest component
+// first keeps the squared length from under- or overflowing, so tiny and
+// huge vectors still have a direction.
+func NormalizeSafe(a Vec3) (Vec3, bool) {
+	m := max(a.x, -a.x, a.y, -a.y, a.z, -a.z)
+	if !(m > 0) || math.IsInf(float64(m), 0) { // m is NaN for NaN components
+		return Vec3{}, false
+	}
+	// Dividing, as 1/m overflows for denormal m.
+	a = Vec3{a.x / m, a.y / m, a.z / m}
+	return vec3mulf(a, 1/sqrtf(vec3dot(a, a))), true
+}
+
 var backgroundColor Vec3 = Vec3{0.1, 0.1, 0.1}
 var diffuseSphereColor Vec3 = Vec3{0.0, 0.7, 0.0}
 var ambientSphereColor Vec3 = Vec3{0.2, 0.3, 0.2}
@@ -93,46 +128,135 @@ type Sphere struct {
 
 type Hit struct {
 	distance float32
-	pos      Vec3
+	pos      Vec3     // the surface normal, facing the ray origin
+	inside   bool     // whether the ray started inside the hit geometry
+	geom     Geometry // the primitive whose surface was hit, nil if none
 }
 
-var hitinfinity Hit = Hit{infinity, Vec3{0, 0, 0}}
+var hitinfinity Hit = Hit{distance: infinity}
 
 type Ray struct {
 	orig, dir Vec3
 }
 
+// At returns the point at distance t along r, in units of the length of
+// its direction.
+func (r *Ray) At(t float32) Vec3 {
+	return vec3add(r.orig, vec3mulf(r.dir, t))
+}
+
+// Offset returns r with its origin moved by eps along normal, which keeps
+// rays leaving a surface from hitting it again due to rounding.
+func (r *Ray) Offset(normal Vec3, eps float32) Ray {
+	return Ray{vec3add(r.orig, vec3mulf(normal, eps)), r.dir}
+}
+
 type Geometry interface {
 	Intersect(h *Hit, r *Ray)
 	Print() // Temporary until fmt handles interfaces.
 }
 
+// MultiHitGeometry is implemented by closed geometry which can report every
+// intersection along a ray, not just the nearest one, as needed for CSG.
+// Hits come in entry/exit pairs ordered by distance, and each hit's normal
+// points out of the solid.
+type MultiHitGeometry interface {
+	Geometry
+	AllHits(r *Ray) []Hit
+}
+
+// RaySphere returns the distance to the nearest intersection in front of the
+// ray origin, which is on the far side if the origin is inside the sphere,
+// or infinity if there is none.
 func (s *Sphere) RaySphere(r *Ray) float32 {
+	t, _ := s.raySphere(r)
+	return t
+}
+
+// raySphere returns the distance to the nearest intersection in front of the
+// ray origin, and whether that is the far side because the origin is
+// inside the sphere.
+func (s *Sphere) raySphere(r *Ray) (float32, bool) {
+	t1, t2, ok := s.roots(r)
+	if !ok || t2 < 0.0 {
+		return infinity, false
+	}
+	if t1 > 0.0 {
+		return t1, false
+	}
+	return t2, true
+}
+
+// roots returns the distances t1 <= t2 at which r intersects the sphere's
+// surface, if it does at all.
+//
+// Rather than b*b - dot(v,v) + r*r, the discriminant is computed from the
+// distance between the center and its projection onto the ray, and only the
+// root which doesn't subtract nearly equal numbers is computed directly.
+// This keeps float32 precision for spheres far from the ray origin.
+func (s *Sphere) roots(r *Ray) (float32, float32, bool) {
 	v := vec3sub(s.center, r.orig)
 	b := vec3dot(v, r.dir)
-	disc := b*b - vec3dot(v, v) + s.radius*s.radius
+	perp := vec3sub(v, vec3mulf(r.dir, b))
+	disc := s.radius*s.radius - vec3dot(perp, perp)
 	if disc < 0.0 {
-		return infinity
+		return infinity, infinity, false
 	}
+	// q is the root of larger magnitude, c/q the other one.
 	d := sqrtf(disc)
-	t2 := b + d
-	if t2 < 0.0 {
-		return infinity
+	q := b + d
+	if b < 0 {
+		q = b - d
 	}
-	t1 := b - d
-	if t1 > 0.0 {
-		return t1
+	if q == 0 {
+		return 0, 0, true
+	}
+	c := vec3dot(v, v) - s.radius*s.radius
+	t1, t2 := q, c/q
+	if t1 > t2 {
+		t1, t2 = t2, t1
 	}
-	return t2
+	return t1, t2, true
+}
+
+// NearestHit returns the nearest intersection in front of the ray origin, if
+// any. For origins inside the sphere that is where the ray leaves it, with
+// inside set and the normal facing the center, as refraction needs for exit
+// points.
+func (s *Sphere) NearestHit(r *Ray) (Hit, bool) {
+	h := hitinfinity
+	s.Intersect(&h, r)
+	return h, h.distance < infinity
 }
 
 func (s *Sphere) Intersect(h *Hit, r *Ray) {
-	lambda := s.RaySphere(r)
+	lambda, inside := s.raySphere(r)
 	if lambda >= h.distance {
 		return
 	}
 	h.distance = lambda
-	h.pos = normalize(vec3add(r.orig, vec3sub(vec3mulf(r.dir, lambda), s.center)))
+	h.pos = normalize(vec3sub(r.At(lambda), s.center))
+	h.inside = inside
+	h.geom = s
+	if inside {
+		// Seen from within, the surface faces the center.
+		h.pos = vec3mulf(h.pos, -1)
+	}
+}
+
+// AllHits returns both intersections of r with the sphere's surface, ordered
+// by distance, including those behind the ray origin so callers can tell
+// whether it starts inside. Tangent rays yield two hits at the same distance.
+func (s *Sphere) AllHits(r *Ray) []Hit {
+	t1, t2, ok := s.roots(r)
+	if !ok {
+		return nil
+	}
+	hits := []Hit{{distance: t1, geom: s}, {distance: t2, geom: s}}
+	for i := range hits {
+		hits[i].pos = normalize(vec3sub(r.At(hits[i].distance), s.center))
+	}
+	return hits
 }
 
 func (s *Sphere) Print() {
@@ -171,40 +295,156 @@ func NewGroup(bound Sphere, children []Geometry) *Group {
 }
 
 type Scene struct {
+	// The unit direction in which the light travels, from the light towards
+	// the scene. Shadow rays are cast along its negation.
 	light Vec3
 	g     Geometry
+	// The color seen by primary rays which hit nothing.
+	Background func(r *Ray) Vec3
+	// If set, shadow rays start delta*max(1, distance) off the surface
+	// rather than delta, since float precision drops with distance.
+	ScaleBias bool
+	// If set, the direction of the light over time, which AtTime uses to
+	// move it. It doesn't need to be normalized.
+	LightPath func(t float32) Vec3
+	// If set, diffuse surfaces are lit by the environment these spherical
+	// harmonics were computed from, instead of the constant ambient color.
+	SHCoefficients *[9]Vec3
 }
 
-func createScene(light Vec3, g Geometry) *Scene {
+// createScene returns a scene lit along light, which doesn't need to be
+// normalized, but needs a direction.
+func createScene(light Vec3, g Geometry, background func(r *Ray) Vec3) (*Scene, error) {
+	l, ok := NormalizeSafe(light)
+	if !ok {
+		return nil, fmt.Errorf("light %v has no direction", light)
+	}
 	scene := new(Scene)
-	scene.light = light
+	scene.light = l
 	scene.g = g
-	return scene
+	scene.Background = background
+	return scene, nil
+}
+
+// SolidBackground returns a background of color c in all directions.
+func SolidBackground(c Vec3) func(*Ray) Vec3 {
+	return func(*Ray) Vec3 { return c }
+}
+
+// GradientBackground returns a background blending from bottom for rays
+// pointing straight down to top for rays pointing straight up.
+func GradientBackground(top, bottom Vec3) func(*Ray) Vec3 {
+	return func(r *Ray) Vec3 {
+		u := 0.5 * (r.dir.y + 1)
+		return vec3add(vec3mulf(bottom, 1-u), vec3mulf(top, u))
+	}
 }
 
 func (s *Scene) rayTrace(r *Ray) Vec3 {
+	return s.trace(r, nil, nil)
+}
+
+// trace shades the primary ray r, storing its hit in primary and recording
+// every ray it casts in pt, if they are not nil. Colors are linear and never
+// clamped here; only f2b clamps them, when encoding bytes.
+func (s *Scene) trace(r *Ray, primary *Hit, pt *PixelTrace) Vec3 {
 	var hit Hit = hitinfinity
 	s.g.Intersect(&hit, r)
+	if primary != nil {
+		*primary = hit
+	}
 	if hit.distance == infinity {
-		return backgroundColor
+		bg := s.Background(r)
+		pt.record("primary", r, &hit, bg)
+		return bg
 	}
+	ambient := s.ambient(hit.pos)
 	g := vec3dot(hit.pos, s.light)
 	if g >= 0.0 {
 		// The hit intersection is in shadow
-		return ambientSphereColor
+		pt.record("primary", r, &hit, ambient)
+		return ambient
+	}
+	litColor := vec3mulf(diffuseSphereColor, -g)
+	totalColor := vec3add(ambient, litColor)
+	pt.record("primary", r, &hit, totalColor)
+
+	bias := delta
+	if s.ScaleBias {
+		bias *= max(1, hit.distance)
 	}
-	p := vec3add(r.orig, vec3add(vec3mulf(r.dir, hit.distance), vec3mulf(hit.pos, delta)))
+	sr := Ray{r.At(hit.distance), vec3mulf(s.light, -1.0)}
+	sr = sr.Offset(hit.pos, bias)
 	hit.distance = infinity
-	s.g.Intersect(&hit, &Ray{p, vec3mulf(s.light, -1.0)})
+	s.g.Intersect(&hit, &sr)
 	if hit.distance < infinity {
 		// There`s an object between us and the light.
-		return ambientSphereColor
+		pt.record("shadow", &sr, &hit, ambient)
+		return ambient
 	}
-	litColor := vec3mulf(diffuseSphereColor, -g)
-	totalColor := vec3add(ambientSphereColor, litColor)
+	pt.record("shadow", &sr, &hit, totalColor)
 	return totalColor
 }
 
+// ambient returns the light a surface with the given normal reflects without
+// direct light.
+func (s *Scene) ambient(normal Vec3) Vec3 {
+	if s.SHCoefficients == nil {
+		return ambientSphereColor
+	}
+	e := vec3mulf(EvaluateSH(*s.SHCoefficients, normal), 1/math.Pi)
+	return Vec3{diffuseSphereColor.x * e.x, diffuseSphereColor.y * e.y, diffuseSphereColor.z * e.z}
+}
+
+// TraceEvent describes a single ray cast while shading a pixel, and the
+// color shading arrived at after casting it.
+type TraceEvent struct {
+	kind     string // "primary" or "shadow"
+	ray      Ray
+	hit      bool
+	geom     Geometry
+	distance float32
+	normal   Vec3
+	color    Vec3
+}
+
+// PixelTrace is the record of all rays cast for all samples of one pixel.
+type PixelTrace struct {
+	x, y   int
+	events []TraceEvent
+	color  Vec3 // the final, averaged pixel color
+}
+
+func (pt *PixelTrace) record(kind string, r *Ray, h *Hit, color Vec3) {
+	if pt == nil {
+		return
+	}
+	e := TraceEvent{kind: kind, ray: *r, hit: h.distance < infinity, color: color}
+	if e.hit {
+		e.geom = h.geom
+		e.distance = h.distance
+		e.normal = h.pos
+	}
+	pt.events = append(pt.events, e)
+}
+
+func (pt *PixelTrace) Print() {
+	fmt.Printf("Pixel %d,%d: %v\n", pt.x, pt.y, pt.color)
+	pt.printEvents(os.Stdout)
+}
+
+func (pt *PixelTrace) printEvents(w io.Writer) {
+	for _, e := range pt.events {
+		fmt.Fprintf(w, "  %-7s orig %v dir %v", e.kind, e.ray.orig, e.ray.dir)
+		if e.hit {
+			fmt.Fprintf(w, " hit %s at %g normal %v", geomName(e.geom), e.distance, e.normal)
+		} else {
+			fmt.Fprint(w, " miss")
+		}
+		fmt.Fprintln(w, " ->", e.color)
+	}
+}
+
 func createSpherePyramid(level int, c Vec3, r float32) Geometry {
 	s := new(Sphere)
 	s.center = c
@@ -227,6 +467,8 @@ func createSpherePyramid(level int, c Vec3, r float32) Geometry {
 	return NewGroup(Sphere{c, 3 * r}, children)
 }
 
+// Texture is an RGBA image with 8 bits per channel. Like all image space
+// coordinates, its rows go from the top of the image (y = 0) to the bottom.
 type Texture struct {
 	w, h int
 	buf  []byte
@@ -245,8 +487,23 @@ func formatTGAShort(buf []byte, offset int, value int) {
 	buf[offset+1] = byte((value >> 8) & 0xff)
 }
 
-func (t *Texture) WriteTGA(w io.Writer) {
-	header := make([]byte, 18)
+func (t *Texture) WriteTGA(w io.Writer) error {
+	if err := writeTGAHeader(w, t.w, t.h); err != nil {
+		return err
+	}
+	return t.writeTGARows(w)
+}
+
+const tgaHeaderSize = 18
+
+// maxTGASize is the largest width and height a TGA header can hold.
+const maxTGASize = 0xffff
+
+func writeTGAHeader(w io.Writer, width, height int) error {
+	if width > maxTGASize || height > maxTGASize {
+		return fmt.Errorf("a %dx%d image is too large for TGA, which allows at most %d pixels per side", width, height, maxTGASize)
+	}
+	header := make([]byte, tgaHeaderSize)
 	header[0] = 0 // ID length
 	header[1] = 0 // Color map type
 	header[2] = 2 // Image type (2 == uncompressed true-color image)
@@ -257,51 +514,172 @@ func (t *Texture) WriteTGA(w io.Writer) {
 	header[7] = 0
 	formatTGAShort(header, 8, 0)
 	formatTGAShort(header, 10, 0)
-	formatTGAShort(header, 12, t.w)
-	formatTGAShort(header, 14, t.h)
-	header[16] = 24 // pixel depth
-	header[17] = 0
+	formatTGAShort(header, 12, width)
+	formatTGAShort(header, 14, height)
+	header[16] = 24   // pixel depth
+	header[17] = 0x20 // image descriptor: rows are stored top to bottom
+
+	_, err := w.Write(header)
+	return err
+}
 
-	w.Write(header)
+// writeTGARows writes the pixels of t as TGA BGR triplets, top row first.
+func (t *Texture) writeTGARows(w io.Writer) error {
 	buf := make([]byte, t.w*3)
-	i := 4 * t.w * (t.h - 1)
 	for y := 0; y < t.h; y++ {
-		o := 0
-		for x := 0; x < t.w; x++ {
-			buf[o] = t.buf[i+2]
-			buf[o+1] = t.buf[i+1]
-			buf[o+2] = t.buf[i+0]
-			o += 3
-			i += 4
+		t.encodeTGARows(buf, y, y+1)
+		if _, err := w.Write(buf); err != nil {
+			return err
 		}
-		i -= 2 * 4 * t.w
-		w.Write(buf)
 	}
+	return nil
+}
+
+// encodeTGARows stores the rows y0 to y1 of t in buf as TGA BGR triplets.
+func (t *Texture) encodeTGARows(buf []byte, y0, y1 int) {
+	i := 4 * t.w * y0
+	o := 0
+	for n := t.w * (y1 - y0); n > 0; n-- {
+		buf[o] = t.buf[i+2]
+		buf[o+1] = t.buf[i+1]
+		buf[o+2] = t.buf[i+0]
+		o += 3
+		i += 4
+	}
+}
+
+// WriteTGAParallel writes the same file as WriteTGA, with the rows split
+// into one band per worker which each encodes and writes at its offset.
+func (t *Texture) WriteTGAParallel(w io.WriterAt, workers int) error {
+	if err := writeTGAHeader(io.NewOffsetWriter(w, 0), t.w, t.h); err != nil {
+		return err
+	}
+	workers = max(1, min(workers, t.h))
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		y0, y1 := t.h*i/workers, t.h*(i+1)/workers
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			buf := make([]byte, 3*t.w*(y1-y0))
+			t.encodeTGARows(buf, y0, y1)
+			_, errs[i] = w.WriteAt(buf, int64(tgaHeaderSize+3*t.w*y0))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTGA reads an uncompressed true-color TGA image with 24 or 32 bits per
+// pixel, as written by WriteTGA, in either row order.
+func ReadTGA(r io.Reader) (*Texture, error) {
+	header := make([]byte, 18)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	depth := int(header[16])
+	if header[1] != 0 || header[2] != 2 || depth != 24 && depth != 32 {
+		return nil, fmt.Errorf("only uncompressed 24 or 32 bit true-color TGA images are supported")
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(header[0])); err != nil {
+		return nil, err
+	}
+	w := int(header[12]) | int(header[13])<<8
+	h := int(header[14]) | int(header[15])<<8
+	topDown := header[17]&0x20 != 0
+	t := NewTexture(w, h)
+	bpp := depth / 8
+	buf := make([]byte, w*bpp)
+	for row := 0; row < h; row++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		y := row
+		if !topDown {
+			y = h - 1 - row
+		}
+		for x := 0; x < w; x++ {
+			p := buf[x*bpp:]
+			a := byte(255)
+			if bpp == 4 {
+				a = p[3]
+			}
+			t.SetRgba(x, y, p[2], p[1], p[0], a)
+		}
+	}
+	return t, nil
+}
+
+// offset returns the index of the pixel at x, y in buf. Coordinates outside
+// of the texture panic, as they would otherwise address another pixel.
+func (t *Texture) offset(x, y int) int {
+	if !(Rect{0, 0, t.w, t.h}).Contains(x, y) {
+		panic(fmt.Sprintf("pixel %d, %d is outside of the %dx%d texture", x, y, t.w, t.h))
+	}
+	return 4 * (t.w*y + x)
 }
 
 func (t *Texture) SetRgba(x int, y int, r byte, g byte, b byte, a byte) {
-	o := 4 * (t.w*y + x)
+	o := t.offset(x, y)
 	t.buf[o] = r
 	t.buf[o+1] = g
 	t.buf[o+2] = b
 	t.buf[o+3] = a
 }
 
+func (t *Texture) GetRgba(x, y int) (r, g, b, a byte) {
+	o := t.offset(x, y)
+	return t.buf[o], t.buf[o+1], t.buf[o+2], t.buf[o+3]
+}
+
+// f2b maps a color component in [0, 1] to a byte, rounding to the nearest
+// step and clamping values outside that range, so 0 and below map to 0, 0.5
+// to 128, and 1 and above to 255. It is the only conversion of colors to
+// bytes, so all outputs agree on it.
 func f2b(f float32) byte {
-	scaled := 0.5 + f*255.0
 	switch {
-	case scaled < 0:
-		scaled = 0
-	case scaled > 255:
-		scaled = 255
+	case !(f > 0): // including NaN
+		return 0
+	case f >= 1:
+		return 255
 	}
-	return byte(scaled)
+	return byte(float64(f)*255 + 0.5)
 }
 
+// SetV sets the pixel at x, y to v, or to magenta if v isn't finite.
 func (t *Texture) SetV(x int, y int, v Vec3) {
+	if !isFinite(v) {
+		v = nonFiniteColor
+	}
 	t.SetRgba(x, y, f2b(v.x), f2b(v.y), f2b(v.z), 255)
 }
 
+// setPremultiplied sets the pixel at x, y to the color v premultiplied by
+// alpha a, encoded with gamma. Like all textures, t stores it straight, as
+// PNG and TGA files do.
+func (t *Texture) setPremultiplied(x, y int, v Vec3, a, gamma float32) {
+	if a > 0 {
+		v = vec3mulf(v, 1/a)
+	}
+	v = gammaEncode(v, gamma)
+	if !isFinite(v) {
+		v = nonFiniteColor
+	}
+	t.SetRgba(x, y, f2b(v.x), f2b(v.y), f2b(v.z), f2b(a))
+}
+
+// GetV returns the color of the pixel at x, y, with components in [0, 1].
+func (t *Texture) GetV(x, y int) Vec3 {
+	r, g, b, _ := t.GetRgba(x, y)
+	return Vec3{float32(r) / 255, float32(g) / 255, float32(b) / 255}
+}
+
 type Rect struct {
 	l int
 	t int
@@ -319,61 +697,588 @@ func newRect(l, t, r, b int) *Rect {
 }
 
 func (r *Rect) isEmpty() bool {
-	return r.l == r.r || r.t == r.b
+	return r.Area() == 0
+}
+
+// Width returns the amount of columns in r, which is 0 if r is inverted.
+func (r Rect) Width() int {
+	return max(r.r-r.l, 0)
+}
+
+// Height returns the amount of rows in r, which is 0 if r is inverted.
+func (r Rect) Height() int {
+	return max(r.b-r.t, 0)
+}
+
+func (r Rect) Area() int {
+	return r.Width() * r.Height()
+}
+
+// Contains reports whether the pixel at x, y is inside r, which includes
+// its left and top edges but not its right and bottom ones.
+func (r Rect) Contains(x, y int) bool {
+	return x >= r.l && x < r.r && y >= r.t && y < r.b
+}
+
+// SplitH cuts r along a horizontal line into a top and a bottom half. The
+// bottom half gets the extra row of odd heights.
+func (r Rect) SplitH() (top, bottom Rect) {
+	m := r.t + r.Height()/2
+	return Rect{r.l, r.t, r.r, m}, Rect{r.l, m, r.r, max(r.b, m)}
+}
+
+// SplitV cuts r along a vertical line into a left and a right half. The
+// right half gets the extra column of odd widths.
+func (r Rect) SplitV() (left, right Rect) {
+	m := r.l + r.Width()/2
+	return Rect{r.l, r.t, m, r.b}, Rect{m, r.t, max(r.r, m), r.b}
+}
+
+// Each calls f for all pixels inside r, row by row from the top-left.
+func (r Rect) Each(f func(x, y int)) {
+	for y := r.t; y < r.b; y++ {
+		for x := r.l; x < r.r; x++ {
+			f(x, y)
+		}
+	}
+}
+
+// Overlap reports whether the interiors of r and other overlap; rects which
+// only share an edge don't.
+func (r Rect) Overlap(other Rect) bool {
+	_, ok := r.Intersect(other)
+	return ok
+}
+
+// Intersect returns the part of r which is also inside of other, and false
+// if there is none.
+func (r Rect) Intersect(other Rect) (Rect, bool) {
+	i := Rect{max(r.l, other.l), max(r.t, other.t), min(r.r, other.r), min(r.b, other.b)}
+	if i.Area() == 0 {
+		return Rect{}, false
+	}
+	return i, true
+}
+
+// Union returns the smallest rect containing both r and other. Empty rects
+// don't contribute to it.
+func (r Rect) Union(other Rect) Rect {
+	if r.Area() == 0 {
+		return other
+	}
+	if other.Area() == 0 {
+		return r
+	}
+	return Rect{min(r.l, other.l), min(r.t, other.t), max(r.r, other.r), max(r.b, other.b)}
 }
 
 type Camera struct {
-	eye Vec3
-	w   int
-	h   int
+	eye   Vec3
+	w     int
+	h     int
+	focal float32 // distance of the image plane, in pixels
+}
+
+// NewCamera returns a camera at eye looking along +z, for an image of w x h
+// pixels spanning a horizontal angle of about 53 degrees.
+func NewCamera(eye Vec3, w, h int) *Camera {
+	return &Camera{eye, w, h, float32(w)}
+}
+
+// Overscan returns a camera for an image which is n pixels larger on each
+// side, and whose center region is the image of c.
+func (c *Camera) Overscan(n int) *Camera {
+	return &Camera{c.eye, c.w + 2*n, c.h + 2*n, c.focal}
 }
 
+// AspectRatio returns the width of the image divided by its height.
+func (c *Camera) AspectRatio() float32 {
+	return float32(c.w) / float32(c.h)
+}
+
+// setRayDirForPixel points r through x, y on the image plane. Both offsets
+// from the center are in pixels, at a distance of focal pixels, so pixels
+// are square for any aspect ratio.
 func (c *Camera) setRayDirForPixel(r *Ray, x, y float32) {
 	r.dir.x = x - float32(c.w)*0.5
 	r.dir.y = y - float32(c.h)*0.5
-	r.dir.z = float32(c.w)
+	r.dir.z = c.focal
 	r.dir.normalize()
 }
 
+// WorldToScreen projects p onto the image of cam, undoing
+// setRayDirForPixel. The position is in image space, continuous from 0, 0 at
+// the top-left corner, so the center of pixel x, y is at x+0.5, y+0.5. It is
+// meaningless unless inFront, which is false for points in the plane of the
+// eye or behind it.
+func WorldToScreen(p Vec3, cam *Camera) (sx, sy float32, inFront bool) {
+	d := vec3sub(p, cam.eye)
+	if d.z <= 0 {
+		return 0, 0, false
+	}
+	s := cam.focal / d.z
+	// Camera space y goes up, image space y down.
+	return float32(cam.w)*0.5 + d.x*s, float32(cam.h)*0.5 - d.y*s, true
+}
+
 type Renderer struct {
 	scene      *Scene
 	t          *Texture
 	cam        *Camera
 	ss         int // oversampling
 	xres, yres int // image resolution
-	jobChan    chan Rect
-	quitChan   chan bool
-	joinChan   chan bool
+	workers    int
+	// If set, AutoWorkers leaves one CPU free for the OS and IO.
+	ReserveCore bool
+	// If set, at most this many workers render at the same time, keeping
+	// the render from saturating shared machines.
+	maxParallelism int
+	active         chan bool // semaphore enforcing maxParallelism
+	chunkw         int       // tile size
+	chunkh         int
+	jobChan        chan job
+	quitChan       chan bool
+	joinChan       chan bool
+	seed           int64 // seed for any random sampling decisions
+	AAMode         AAMode
+	TileOrder      TileOrder
+	stats          RenderStats
+	// With TileInterleaved, the distance between rows rendered in the
+	// same pass, and whether checkpoints fill the rows not rendered yet
+	// with the nearest one which is.
+	Interleave    int
+	FillSnapshots bool
+	rowDone       []bool // if FillSnapshots, written under checkpointLock
+	// The exponent by which colors are encoded in t, which applies 1/Gamma
+	// to them. 0 and 1 keep them linear; other buffers always are.
+	Gamma float32
+	// If set, Render prints a trace of this ray to stderr once it's done.
+	DebugRay *Ray
+	// If set, the background is transparent, and the alpha of each pixel
+	// is the fraction of its samples which hit geometry.
+	Transparent bool
+
+	// Adaptive sampling: after the first pass, up to AdaptivePasses more
+	// passes add ss*ss jittered samples to each pixel whose estimated
+	// variance of its mean luminance is above VarianceThreshold, until it
+	// has MaxSamples, if set. Disabled if VarianceThreshold is 0.
+	VarianceThreshold float32
+	AdaptivePasses    int
+	MaxSamples        int
+	VarianceBuffer    []float32 // the final variance estimate per pixel
+	accum             []sampleStats
+	refined           int64 // pixels refined in the current pass, accessed atomically
+
+	// If OutputEveryN is set, CheckpointSaver is called with a copy of the
+	// image after every N completed tiles.
+	OutputEveryN    int
+	CheckpointSaver func(t *Texture, completedTiles int)
+	// Held for reading while a worker writes a tile, and for writing while
+	// a checkpoint copies the texture.
+	checkpointLock sync.RWMutex
+
+	onProgress      func(Progress)
+	progressChan    chan bool
+	progressUpdates chan Progress // closed at the end of the render
+	tilesDone       int64         // accessed atomically
+	tilesTotal      int
+
+	// Optional auxiliary buffers as denoiser input, filled alongside t.
+	albedo, normal *Texture
+	// Optional distance to the nearest primary hit per pixel, infinity
+	// where nothing was hit.
+	depth []float32
+	// Optional unclamped colors, as input to post-processing.
+	hdr *FloatImage
+
+	// The image row at which t starts, if it only holds a band of the
+	// image while streaming.
+	ty0 int
+
+	// If set, the seed is derived from the output filename, giving each
+	// frame of a batch different but reproducible randomness.
+	SeedFromOutput bool
+
+	// Pixels with NaN or infinite components, and the first of them in
+	// scan order.
+	nonFiniteCount int
+	nonFinite      *badPixel
+	nonFiniteLock  sync.Mutex
+}
+
+// SeedFromString returns a stable seed for s, using the FNV-1a hash of its bytes.
+func SeedFromString(s string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return int64(h.Sum64())
+}
+
+// NewRenderer returns a renderer for an image of the camera's resolution.
+// t may be nil if the image is only rendered with RenderStreamed.
+func NewRenderer(scene *Scene, t *Texture, cam *Camera, ss int) *Renderer {
+	ren := new(Renderer)
+	ren.scene = scene
+	ren.t = t
+	ren.cam = cam
+	ren.ss = ss
+	ren.xres = cam.w
+	ren.yres = cam.h
+	ren.workers = 8
+	ren.chunkw = 16
+	ren.chunkh = 16
+	ren.jobChan = make(chan job)
+	ren.quitChan = make(chan bool)
+	ren.joinChan = make(chan bool)
+	return ren
+}
+
+// RenderStats describes the work done by a render.
+type RenderStats struct {
+	pixels, samples int64
+	elapsed         time.Duration
+	// Per pixel, if sampling adaptively.
+	variance    []float32
+	pixelCounts []int32 // of samples taken
+}
+
+func (st *RenderStats) AvgSamples() float64 {
+	if st.pixels == 0 {
+		return 0
+	}
+	return float64(st.samples) / float64(st.pixels)
+}
+
+func (st *RenderStats) Print() {
+	fmt.Fprintf(os.Stderr, "rendered %d pixels with %d samples (%.2f per pixel) in %v\n",
+		st.pixels, st.samples, st.AvgSamples(), st.elapsed)
+}
+
+// AutoWorkers sets the worker count to the amount of usable CPUs, less one
+// if ReserveCore is set and there is more than one.
+func (ren *Renderer) AutoWorkers() *Renderer {
+	ren.workers = runtime.GOMAXPROCS(0)
+	if ren.ReserveCore {
+		ren.workers = max(1, ren.workers-1)
+	}
+	return ren
+}
+
+// SeedFromFilename sets the seed to SeedFromString(path), so each output
+// file of a batch gets different but reproducible random sampling.
+func (ren *Renderer) SeedFromFilename(path string) {
+	ren.seed = SeedFromString(path)
+}
+
+// SetSeed sets the seed for all random sampling decisions.
+func (ren *Renderer) SetSeed(seed int64) {
+	ren.seed = seed
+}
+
+// ReplayFlags returns the command line flags which reproduce the sampling of
+// this renderer, for logging along with a render.
+func (ren *Renderer) ReplayFlags() string {
+	// Tiles pick the random sources of their pixels, so their order matters.
+	f := fmt.Sprintf("-seed %d -aa %v -tile-order %v", ren.seed, ren.AAMode, ren.TileOrder)
+	if ren.TileOrder == TileInterleaved {
+		f += fmt.Sprintf(" -interleave %d", ren.Interleave)
+	}
+	if ren.VarianceThreshold > 0 {
+		f += fmt.Sprintf(" -adaptive %g -adaptive-passes %d -max-samples %d", ren.VarianceThreshold, ren.AdaptivePasses, ren.MaxSamples)
+	}
+	if ren.Gamma != 0 && ren.Gamma != 1 {
+		f += fmt.Sprintf(" -gamma %g", ren.Gamma)
+	}
+	return f
+}
+
+// pixel is the average of all samples taken for one pixel.
+type pixel struct {
+	color  Vec3
+	albedo Vec3    // the surface color, without any lighting
+	normal Vec3    // the surface normal, or zero where nothing was hit
+	depth  float32 // distance to the nearest hit of all samples
+	m2     float32 // sum of squared deviations from the mean luminance
+	alpha  float32 // fraction of samples which hit, if rendering transparently
+}
+
+// colorSum accumulates colors in float64, which keeps the mean of many
+// samples as exact as the samples themselves.
+type colorSum struct {
+	x, y, z float64
+}
+
+func (s *colorSum) add(c Vec3) {
+	s.x += float64(c.x)
+	s.y += float64(c.y)
+	s.z += float64(c.z)
+}
+
+// mean returns the sum divided by n.
+func (s *colorSum) mean(n int) Vec3 {
+	f := 1 / float64(n)
+	return Vec3{float32(s.x * f), float32(s.y * f), float32(s.z * f)}
+}
+
+// TileOrder selects the order in which tiles are rendered.
+type TileOrder int
+
+const (
+	TileScanline    TileOrder = iota // rows of tiles from the top-left
+	TileCenterFirst                  // by distance from the image center, for a quicker preview of the subject
+	TileInterleaved                  // whole rows, every Interleave-th one per pass, for a quick preview of all of it
+)
+
+func parseTileOrder(s string) (TileOrder, error) {
+	switch s {
+	case "scanline":
+		return TileScanline, nil
+	case "center":
+		return TileCenterFirst, nil
+	case "interleaved":
+		return TileInterleaved, nil
+	}
+	return TileScanline, fmt.Errorf("unknown tile order %q", s)
+}
+
+func (o TileOrder) String() string {
+	return [...]string{"scanline", "center", "interleaved"}[o]
+}
+
+// AAMode selects where within a pixel its subsamples are taken.
+type AAMode int
+
+const (
+	AAGrid   AAMode = iota // a regular ss x ss grid
+	AAJitter               // a random position within each grid cell
+	AAHalton               // the points of the 2,3 Halton sequence
+)
+
+func parseAAMode(s string) (AAMode, error) {
+	switch s {
+	case "grid":
+		return AAGrid, nil
+	case "jitter":
+		return AAJitter, nil
+	case "halton":
+		return AAHalton, nil
+	}
+	return AAGrid, fmt.Errorf("unknown anti-aliasing mode %q", s)
+}
+
+func (m AAMode) String() string {
+	return [...]string{"grid", "jitter", "halton"}[m]
+}
+
+// halton returns the i-th element of the van der Corput sequence in base b.
+func halton(i, b int) float32 {
+	f, r := float32(1), float32(0)
+	for ; i > 0; i /= b {
+		f /= float32(b)
+		r += f * float32(i%b)
+	}
+	return r
+}
+
+// subpixel returns the offset of subsample ssx, ssy from the top-left corner
+// of its pixel, in [0, 1).
+func (ren *Renderer) subpixel(mode AAMode, ssx, ssy int, rng *rand.Rand) (float32, float32) {
+	ss := float32(ren.ss)
+	switch mode {
+	case AAJitter:
+		return (float32(ssx) + rng.Float32()) / ss, (float32(ssy) + rng.Float32()) / ss
+	case AAHalton:
+		i := ssx*ren.ss + ssy + 1
+		return halton(i, 2), halton(i, 3)
+	}
+	return float32(ssx) / ss, float32(ssy) / ss
+}
+
+// RayFor returns the primary ray through the camera-space position x, y,
+// where 0, 0 is the bottom-left corner of the image. The hot path uses
+// setRayDirForPixel instead, which reuses a ray.
+func (c *Camera) RayFor(x, y float32) Ray {
+	r := Ray{orig: c.eye}
+	c.setRayDirForPixel(&r, x, y)
+	return r
+}
+
+// GenerateJitteredRay returns a ray through a uniformly random position
+// within the pixel at x, y, for stochastic anti-aliasing with a single
+// sample per pixel.
+func (c *Camera) GenerateJitteredRay(x, y int, rng *rand.Rand) Ray {
+	return c.RayFor(float32(x)+0.5+(rng.Float32()-0.5), float32(y)+0.5+(rng.Float32()-0.5))
+}
+
+// renderPixel computes the supersampled color of the pixel at x, y in
+// camera space, placing subsamples according to mode and recording all rays
+// cast in pt if it is not nil. rng is only used for stochastic modes.
+func (ren *Renderer) renderPixel(ray *Ray, x, y int, mode AAMode, rng *rand.Rand, pt *PixelTrace) pixel {
+	p := pixel{depth: infinity}
+	var color, albedo, normal colorSum
+	var hit Hit
+	var k, mean float32
+	for ssx := 0; ssx < ren.ss; ssx++ {
+		for ssy := 0; ssy < ren.ss; ssy++ {
+			dx, dy := ren.subpixel(mode, ssx, ssy, rng)
+			var xres float32 = float32(x) + dx
+			var yres float32 = float32(y) + dy
+
+			ren.cam.setRayDirForPixel(ray, xres, yres)
+			c := ren.scene.trace(ray, &hit, pt)
+			if ren.Transparent && hit.distance == infinity {
+				c = Vec3{}
+			}
+			color.add(c)
+
+			// Welford's online update of the luminance variance.
+			k++
+			l := luminance(c)
+			d := l - mean
+			mean += d / k
+			p.m2 += d * (l - mean)
+
+			if hit.distance == infinity {
+				// The background is its own albedo.
+				albedo.add(c)
+			} else {
+				albedo.add(diffuseSphereColor)
+				normal.add(hit.pos)
+				p.depth = min(p.depth, hit.distance)
+				p.alpha++
+			}
+		} // END for each y subsample
+	} // END for each x subsample
+	n := ren.ss * ren.ss
+	p.color = color.mean(n)
+	p.albedo = albedo.mean(n)
+	p.normal = normal.mean(n)
+	p.alpha /= float32(n)
+	return p
+}
+
+// tileRand returns the random number source for the tile or pixel at x, y
+// in the given render pass, which is independent of the order in which
+// workers pick up tiles.
+func (ren *Renderer) tileRand(x, y, pass int) *rand.Rand {
+	return rand.New(rand.NewSource(ren.seed ^ int64(y*ren.xres+x) ^ int64(pass)<<40))
 }
 
 func (ren *Renderer) renderRect(tint Vec3, r *Rect) {
 	ray := Ray{orig: ren.cam.eye}
+	var rng *rand.Rand
+	if ren.AAMode == AAJitter {
+		rng = ren.tileRand(r.l, r.t, 0)
+	}
 
+	var samples int64
 	for y := r.t; y < r.b; y++ {
+		// Camera space y goes up from the bottom of the image.
+		cy := ren.cam.h - (y + 1)
+		ty := y - ren.ty0
 		for x := r.l; x < r.r; x++ {
-			var g Vec3
-			for ssx := 0; ssx < ren.ss; ssx++ {
-				for ssy := 0; ssy < ren.ss; ssy++ {
-					var xres float32 = float32(x) + float32(ssx)/float32(ren.ss)
-					var yres float32 = float32(y) + float32(ssy)/float32(ren.ss)
+			p := ren.renderPixel(&ray, x, cy, ren.AAMode, rng, nil)
+			samples += int64(ren.ss * ren.ss)
+			if ren.Transparent {
+				ren.t.setPremultiplied(x, ty, p.color, p.alpha, ren.Gamma)
+			} else {
+				ren.t.SetV(x, ty, gammaEncode(p.color, ren.Gamma))
+			}
+			if ren.hdr != nil {
+				ren.hdr.Set(x, y, p.color)
+			}
+			ren.checkFinite(x, y, p.color)
+			if ren.accum != nil {
+				ren.accum[y*ren.xres+x] = sampleStats{int32(ren.ss * ren.ss), p.color, p.m2}
+			}
+			if ren.albedo != nil {
+				ren.albedo.SetV(x, ty, p.albedo)
+			}
+			if ren.normal != nil {
+				// Map the normal's [-1, 1] range into the texture's [0, 1].
+				ren.normal.SetV(x, ty, vec3add(vec3mulf(p.normal, 0.5), Vec3{0.5, 0.5, 0.5}))
+			}
+			if ren.depth != nil {
+				ren.depth[y*ren.xres+x] = p.depth
+			}
+		} // END for each x pixel
+	} // END for each y pixel
+	atomic.AddInt64(&ren.stats.pixels, int64(r.Area()))
+	if ren.rowDone != nil {
+		for y := r.t; y < r.b; y++ {
+			ren.rowDone[y] = true
+		}
+	}
+	atomic.AddInt64(&ren.stats.samples, samples)
+}
 
-					ren.cam.setRayDirForPixel(&ray, xres, yres)
-					g = vec3add(g, ren.scene.rayTrace(&ray))
-				} // END for each y subsample
-			} // END for each x subsample
+// DebugPixel synchronously renders the pixel at x, y of the output image,
+// where 0, 0 is the top-left corner, and returns a trace of every ray cast
+// for it.
+func (ren *Renderer) DebugPixel(x, y int) *PixelTrace {
+	pt := &PixelTrace{x: x, y: y}
+	ray := Ray{orig: ren.cam.eye}
+	pt.color = ren.renderPixel(&ray, x, ren.cam.h-(y+1), ren.AAMode, ren.pixelRand(x, y), pt).color
+	return pt
+}
 
-			ren.t.SetV(x, ren.cam.h-(y+1), vec3mulf(g, 1.0/float32(ren.ss*ren.ss)))
+// pixelRand returns the random number source renderRect uses for the pixel
+// at x, y, or nil if the AA mode doesn't need one. Jittered pixels share the
+// source of their tile, so the samples of the pixels before x, y in it are
+// drawn again.
+func (ren *Renderer) pixelRand(x, y int) *rand.Rand {
+	if ren.AAMode != AAJitter {
+		return nil
+	}
+	for _, r := range ren.tiles(0, ren.yres) {
+		if !r.Contains(x, y) {
+			continue
+		}
+		ray := Ray{orig: ren.cam.eye}
+		rng := ren.tileRand(r.l, r.t, 0)
+		for py := r.t; py <= y; py++ {
+			for px := r.l; px < r.r && (py < y || px < x); px++ {
+				ren.renderPixel(&ray, px, ren.cam.h-(py+1), ren.AAMode, rng, nil)
+			}
+		}
+		return rng
+	}
+	return nil
+}
 
-		} // END for each x pixel
-	} // END for each y pixel
+// job is a tile to render in a given pass, where pass 0 is the initial
+// render and later passes add samples to it.
+type job struct {
+	r    Rect
+	pass int
+	wg   *sync.WaitGroup // if not nil, notified when the job is done
 }
 
 func (renderer *Renderer) worker(tint Vec3) {
 	jobChan := renderer.jobChan
 	for {
 		select {
-		case r := <-jobChan:
-			renderer.renderRect(tint, &r)
+		case j := <-jobChan:
+			if renderer.active != nil {
+				renderer.active <- true
+			}
+			if j.pass == 0 {
+				if renderer.OutputEveryN > 0 {
+					renderer.checkpointLock.RLock()
+					renderer.renderRect(tint, &j.r)
+					renderer.checkpointLock.RUnlock()
+				} else {
+					renderer.renderRect(tint, &j.r)
+				}
+				renderer.tileDone()
+			} else {
+				renderer.refineRect(&j.r, j.pass)
+			}
+			if renderer.active != nil {
+				<-renderer.active
+			}
+			if j.wg != nil {
+				j.wg.Done()
+			}
 		case <-renderer.quitChan:
 			renderer.joinChan <- true
 			return
@@ -381,42 +1286,699 @@ func (renderer *Renderer) worker(tint Vec3) {
 	}
 }
 
+// Render renders the whole image, distributing its tiles over all workers.
+// If ctx is cancelled no more tiles are started, and once the workers are
+// done with theirs the context's error is returned.
+func (ren *Renderer) Render(ctx context.Context) error {
+	start, progressDone := ren.startWorkers()
+	var err error
+	if ren.VarianceThreshold > 0 {
+		err = ren.renderAdaptive(ctx)
+	} else {
+		err = ren.dispatch(ctx, 0, ren.yres, 0, nil)
+	}
+	ren.stopWorkers(start, progressDone)
+	if ren.DebugRay != nil {
+		ren.printDebugRay(os.Stderr)
+	}
+	return err
+}
+
+// RenderStreamed renders the image in bands of tile rows, writing each to w
+// as TGA as soon as it's done, so only one band needs to be in memory.
+// Auxiliary buffers and adaptive sampling aren't supported. If ctx is
+// cancelled, the bands not rendered yet are written empty, so w still gets a
+// complete image, and the context's error is returned.
+func (ren *Renderer) RenderStreamed(ctx context.Context, w io.Writer) error {
+	if err := writeTGAHeader(w, ren.xres, ren.yres); err != nil {
+		return err
+	}
+	t := ren.t
+	defer func() { ren.t, ren.ty0 = t, 0 }()
+	start, progressDone := ren.startWorkers()
+	defer ren.stopWorkers(start, progressDone)
+	var wg sync.WaitGroup
+	var err error
+	for y := 0; y < ren.yres; y += ren.chunkh {
+		y1 := min(y+ren.chunkh, ren.yres)
+		ren.t = NewTexture(ren.xres, y1-y)
+		ren.ty0 = y
+		if err == nil {
+			err = ren.dispatch(ctx, y, y1, 0, &wg)
+			wg.Wait()
+		}
+		if werr := ren.t.writeTGARows(w); werr != nil {
+			return werr
+		}
+	}
+	if ren.DebugRay != nil {
+		ren.printDebugRay(os.Stderr)
+	}
+	return err
+}
+
+// startWorkers prepares a render and starts the workers, along with
+// the progress reporter if needed.
+func (ren *Renderer) startWorkers() (time.Time, chan bool) {
+	start := time.Now()
+	ren.stats = RenderStats{}
+	ren.nonFiniteLock.Lock()
+	ren.nonFiniteCount, ren.nonFinite = 0, nil
+	ren.nonFiniteLock.Unlock()
+	ren.tilesDone = 0
+	ren.tilesTotal = len(ren.tiles(0, ren.yres))
+	ren.rowDone = nil
+	if ren.TileOrder == TileInterleaved && ren.FillSnapshots {
+		ren.rowDone = make([]bool, ren.yres)
+	}
+	var progressDone chan bool
+	if ren.onProgress != nil {
+		ren.progressChan = make(chan bool, 1)
+		progressDone = make(chan bool)
+		go ren.reportProgress(start, progressDone)
+	}
+	ren.active = nil
+	if ren.maxParallelism > 0 && ren.maxParallelism < ren.workers {
+		ren.active = make(chan bool, ren.maxParallelism)
+	}
+	for w := 0; w < ren.workers; w++ {
+		tint := Vec3{0.5, float32(w) / float32(ren.workers), 0.5}
+		go ren.worker(tint)
+	}
+	return start, progressDone
+}
+
+// stopWorkers waits for the workers to finish their last tile and ends the
+// render started at start.
+func (ren *Renderer) stopWorkers(start time.Time, progressDone chan bool) {
+	for w := 0; w < ren.workers; w++ {
+		ren.quitChan <- true
+	}
+	for w := 0; w < ren.workers; w++ {
+		<-ren.joinChan
+	}
+	if ren.progressChan != nil {
+		close(ren.progressChan)
+		<-progressDone
+		ren.progressChan = nil
+	}
+	if ren.progressUpdates != nil {
+		close(ren.progressUpdates)
+		ren.progressUpdates = nil
+		ren.onProgress = nil
+	}
+	ren.stats.elapsed = time.Since(start)
+}
+
+// dispatch sends all tiles between the image rows y0 and y1 to the workers
+// for the given pass, until ctx is cancelled. Tiles are in image space,
+// starting at the top-left.
+func (ren *Renderer) dispatch(ctx context.Context, y0, y1, pass int, wg *sync.WaitGroup) error {
+	for _, r := range ren.tiles(y0, y1) {
+		if debug {
+			ren.checkTile(r)
+		}
+		if wg != nil {
+			wg.Add(1)
+		}
+		select {
+		case ren.jobChan <- job{r, pass, wg}:
+		case <-ctx.Done():
+			if wg != nil {
+				wg.Done()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// checkTile panics unless r is a non-empty part of the image, so tiles
+// reaching past the edges fail where they are made rather than at the first
+// pixel written outside of the texture.
+func (ren *Renderer) checkTile(r Rect) {
+	if c, ok := r.Intersect(Rect{0, 0, ren.xres, ren.yres}); !ok || c != r {
+		panic(fmt.Sprintf("tile %v is not within the %dx%d image", r, ren.xres, ren.yres))
+	}
+}
+
+// tiles returns the tiles between the image rows y0 and y1 in the order
+// they are rendered.
+func (ren *Renderer) tiles(y0, y1 int) []Rect {
+	var tiles []Rect
+	if ren.TileOrder == TileInterleaved {
+		k := max(ren.Interleave, 1)
+		for pass := 0; pass < k; pass++ {
+			for y := y0 + pass; y < y1; y += k {
+				tiles = append(tiles, Rect{0, y, ren.xres, y + 1})
+			}
+		}
+		return tiles
+	}
+	band := Rect{0, y0, ren.xres, y1}
+	for y := y0; y < y1; y += ren.chunkh {
+		for x := 0; x < ren.xres; x += ren.chunkw {
+			// Tiles at the right and bottom edges may be partial.
+			tile, _ := Rect{x, y, x + ren.chunkw, y + ren.chunkh}.Intersect(band)
+			tiles = append(tiles, tile)
+		}
+	}
+	if ren.TileOrder == TileCenterFirst {
+		// Doubled coordinates keep the centers integral.
+		cx, cy := ren.xres, ren.yres
+		dist := func(r Rect) int {
+			dx, dy := r.l+r.r-cx, r.t+r.b-cy
+			return dx*dx + dy*dy
+		}
+		sort.SliceStable(tiles, func(i, j int) bool { return dist(tiles[i]) < dist(tiles[j]) })
+	}
+	return tiles
+}
+
+func (t *Texture) Copy() *Texture {
+	c := NewTexture(t.w, t.h)
+	copy(c.buf, t.buf)
+	return c
+}
+
+// Crop returns a copy of the part of t inside r, which must lie within t.
+func (t *Texture) Crop(r Rect) *Texture {
+	c := NewTexture(r.Width(), r.Height())
+	if r.isEmpty() {
+		return c
+	}
+	for y := r.t; y < r.b; y++ {
+		copy(c.buf[4*c.w*(y-r.t):4*c.w*(y-r.t+1)], t.buf[t.offset(r.l, y):t.offset(r.r-1, y)+4])
+	}
+	return c
+}
+
+// checkpoint passes a consistent copy of the image to the CheckpointSaver.
+func (ren *Renderer) checkpoint(completedTiles int) {
+	ren.checkpointLock.Lock()
+	t := ren.t.Copy()
+	if ren.rowDone != nil {
+		fillRows(t, ren.rowDone)
+	}
+	ren.checkpointLock.Unlock()
+	ren.CheckpointSaver(t, completedTiles)
+}
+
+// fillRows copies the nearest row of t which is done over each one which
+// isn't, preferring the one above at equal distances.
+func fillRows(t *Texture, done []bool) {
+	row := 4 * t.w
+	for y := range done {
+		if done[y] {
+			continue
+		}
+		for d := 1; d < len(done); d++ {
+			if y-d >= 0 && done[y-d] {
+				copy(t.buf[y*row:(y+1)*row], t.buf[(y-d)*row:(y-d+1)*row])
+				break
+			}
+			if y+d < len(done) && done[y+d] {
+				copy(t.buf[y*row:(y+1)*row], t.buf[(y+d)*row:(y+d+1)*row])
+				break
+			}
+		}
+	}
+}
+
+func writeTGA(path string, t *Texture) error {
+	od, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	return saveTGA(od, t)
+}
+
+// openOutput opens the file at path for writing, exiting if that fails, so
+// no render is wasted on an output which can't be saved. Existing contents
+// are kept until saveTGA replaces them.
+func openOutput(path string) *os.File {
+	od, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return od
+}
+
+// saveTGA replaces the contents of od with t and closes it.
+func saveTGA(od *os.File, t *Texture) error {
+	err := od.Truncate(0)
+	if err == nil {
+		err = t.WriteTGAParallel(od, runtime.GOMAXPROCS(0))
+	}
+	if cerr := od.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %v", od.Name(), err)
+	}
+	return nil
+}
+
+// printTimeLimit tells how much of the image ren rendered before the
+// -max-time limit stopped it. With progress, the progress line is ended
+// first, as only the report of the last tile does that.
+func printTimeLimit(ren *Renderer, limit time.Duration, progress bool) {
+	if p := ren.Completed(); p.tilesDone < p.tilesTotal {
+		if progress {
+			fmt.Fprintln(os.Stderr)
+		}
+		fmt.Fprintf(os.Stderr, "stopped after %v with %.1f%% of the tiles rendered\n", limit, 100*p.Fraction())
+	} else {
+		fmt.Fprintf(os.Stderr, "stopped refining the image after %v\n", limit)
+	}
+}
+
+// sceneFlags are the flags besides those of Renderer.ReplayFlags which
+// change the rendered image.
+var sceneFlags = []string{"ssaa-filter", "lod", "prune", "time", "light-orbit", "scale-bias", "sh-ambient", "post", "bilateral", "denoise", "composite-under"}
+
+// setFlags returns those of names which were given on the command line, with
+// their values, in the form they were parsed from.
+func setFlags(names []string) string {
+	var s []string
+	flag.Visit(func(f *flag.Flag) {
+		if !slices.Contains(names, f.Name) {
+			return
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			s = append(s, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		} else {
+			s = append(s, fmt.Sprintf("-%s %s", f.Name, f.Value))
+		}
+	})
+	return strings.Join(s, " ")
+}
+
+// mustSaveImage is saveImage, exiting if it fails.
+func mustSaveImage(od *os.File, t *Texture) {
+	if err := saveImage(od, t); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 func main() {
+	var outputs outputList
+	flag.Var(&outputs, "o", "write a `buffer:path` of the render, where buffer is one of beauty, albedo, normal or depth and defaults to beauty, as TGA or as PNG if path ends in .png; may be repeated (default out.tga)")
+	seedFromOutput := flag.Bool("seed-from-output", false, "derive the random seed from the output filename")
+	seed := flag.Int64("seed", 0, "seed for random sampling decisions, as logged with -verbose")
+	preview := flag.Bool("preview-term", false, "print a preview of the image to the terminal")
+	debugPixel := flag.String("debug-pixel", "", "only trace the pixel at `x,y` and print all rays cast for it")
+	debugRay := flag.String("debug-ray", "", "after rendering, print all rays cast for the ray from `x,y,z,dx,dy,dz`")
+	width := flag.Int("width", 1024, "width of the image in pixels")
+	height := flag.Int("height", 768, "height of the image in pixels")
+	workers := flag.Int("workers", 0, "amount of parallel render workers, 0 picks one per usable CPU")
+	reserveCore := flag.Bool("reserve-core", false, "with -workers 0, leave one CPU free for the OS and IO")
+	checkpointEvery := flag.Int("checkpoint-every", 0, "write the image so far to checkpoint_NNNN.tga after every `N` tiles")
+	maxParallelism := flag.Int("max-parallelism", 0, "maximum amount of workers rendering at the same time, 0 for no limit")
+	verbose := flag.Bool("verbose", false, "print render settings to stderr")
+	albedoOutput := flag.String("albedo", "", "same as -o albedo:`path`")
+	normalOutput := flag.String("normal", "", "same as -o normal:`path`")
+	stats := flag.Bool("stats", false, "print render statistics to stderr")
+	progress := flag.Bool("progress", false, "print the render progress to stderr")
+	adaptive := flag.Float64("adaptive", 0, "add samples to pixels whose mean's estimated variance is above `threshold`")
+	adaptivePasses := flag.Int("adaptive-passes", 4, "maximum amount of adaptive sampling passes")
+	maxSamples := flag.Int("max-samples", 0, "maximum amount of samples per pixel when sampling adaptively, 0 for no limit")
+	tileOrder := flag.String("tile-order", "scanline", "order of rendering tiles, one of scanline, center for the center first, or interleaved rows")
+	interleave := flag.Int("interleave", 8, "with -tile-order interleaved, render every `k`th row in each pass")
+	fillCheckpoints := flag.Bool("fill-checkpoints", false, "with -tile-order interleaved, fill rows of checkpoints not rendered yet with the nearest one which is")
+	aaMode := flag.String("aa", "grid", "subsample placement, one of grid, jitter or halton")
+	overscan := flag.Int("overscan", 0, "render `n` extra pixels on each side of the image and crop them on output")
+	ssaa := flag.Int("ssaa", 1, "render at `k` times the resolution with one sample per pixel, and filter the image down")
+	ssaaFilter := flag.String("ssaa-filter", "box", "filter for scaling down -ssaa renders, one of box, lanczos, bilinear or nearest")
+	compositeUnder := flag.String("composite-under", "", "render with a transparent background and lay the image over the one at `path`, which must have the same size")
+	stream := flag.Bool("stream", false, "write tiles to the output as they are done instead of keeping the whole image in memory")
+	scaleBias := flag.Bool("scale-bias", false, "offset shadow rays from surfaces in proportion to their distance")
+	lightOrbit := flag.Float64("light-orbit", 0, "turn the light around the vertical axis once every `period` seconds of -time")
+	sceneTime := flag.Float64("time", 0, "render the scene as it is at `t` seconds")
+	shAmbient := flag.Int("sh-ambient", 0, "light surfaces by the background, projected onto spherical harmonics from `n` samples, instead of a constant ambient color")
+	analyze := flag.Bool("analyze", false, "print statistics of the scene geometry instead of rendering it")
+	prune := flag.Float64("prune", 0, "leave out spheres which appear smaller than `radius` pixels")
+	lod := flag.Float64("lod", 0, "reduce sub-pyramids to one sphere while they appear smaller than `radius` pixels")
+	strict := flag.Bool("strict", false, "fail if any pixel is NaN or infinite")
+	maxTime := flag.Duration("max-time", 0, "stop starting tiles after `duration` and write the image rendered so far, 0 for no limit")
+	gamma := flag.Float64("gamma", 1, "encode the image for a display with this gamma, 1 for linear output")
+	post := flag.String("post", "", "post-process the image with a comma separated list of `stages`, from box:radius, blur:sigma, unsharp:sigma:amount, bloom:threshold:sigma:strength, vignette:strength:radius and chroma:amount")
+	bilateral := flag.String("bilateral", "", "smooth the image guided by normals and depth, with standard deviations `spatial:normal:depth` in pixels, normal differences and relative depth differences")
+	denoise := flag.Int("denoise", 0, "smooth the image with a denoise filter of the given `radius`")
+	version := flag.Bool("version", false, "print the version and the supported features, and exit")
+	flag.Parse()
+	if *version {
+		fmt.Printf("gotrace %s\n%s\n", Version, strings.Join(Capabilities(), " "))
+		return
+	}
+	if len(outputs) == 0 {
+		outputs = outputList{{"beauty", "out.tga"}}
+	}
+	if *albedoOutput != "" {
+		outputs = append(outputs, output{"albedo", *albedoOutput})
+	}
+	if *normalOutput != "" {
+		outputs = append(outputs, output{"normal", *normalOutput})
+	}
+
 	level := 8
-	chunkw := 16
-	chunkh := 16
-	w := 1024
-	h := 768
-	workers := 8
+	w := *width
+	h := *height
+	if w <= 0 || h <= 0 {
+		fmt.Fprintln(os.Stderr, "-width and -height must be positive")
+		os.Exit(2)
+	}
+	if *overscan < 0 {
+		fmt.Fprintln(os.Stderr, "-overscan must not be negative")
+		os.Exit(2)
+	}
 	ss := 4 // oversampling - use 4 to get 16 samples
-	t := NewTexture(w, h)
-	light := normalize(Vec3{-1.0, -3.0, 2.0})
-	sp := createSpherePyramid(level, Vec3{0.0, -1.0, 0.0}, 1.0)
-	scene := createScene(light, sp)
+	// The rendered image includes the overscan, which is cropped on output.
+	ow, oh := w+2**overscan, h+2**overscan
+	// With -ssaa, all of it is rendered k times larger and scaled down
+	// before post-processing.
+	k := *ssaa
+	if k < 1 {
+		fmt.Fprintln(os.Stderr, "-ssaa must be at least 1")
+		os.Exit(2)
+	}
+	filter, err := parseResizeFilter(*ssaaFilter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if k > 1 {
+		if outputs.wants("albedo") || outputs.wants("normal") || outputs.wants("depth") || *denoise > 0 || *bilateral != "" || *stream || *debugPixel != "" {
+			fmt.Fprintln(os.Stderr, "-ssaa only scales down the image itself")
+			os.Exit(2)
+		}
+		// The texture and float image take 16 bytes per rendered pixel.
+		if ow*oh*k*k > maxSSAAPixels {
+			fmt.Fprintf(os.Stderr, "-ssaa %d would render %d megapixels, more than the limit of %d\n", k, ow*oh*k*k>>20, maxSSAAPixels>>20)
+			os.Exit(2)
+		}
+		ss = 1
+	}
+	rw, rh := k*ow, k*oh
+	// Checkpoints are TGA files of the whole rendered image.
+	tooLarge := *checkpointEvery > 0 && (rw > maxTGASize || rh > maxTGASize)
+	for _, o := range outputs {
+		tooLarge = tooLarge || !isPNG(o.path) && (w > maxTGASize || h > maxTGASize)
+	}
+	if tooLarge {
+		fmt.Fprintf(os.Stderr, "TGA images can't be wider or higher than %d pixels, write PNG instead\n", maxTGASize)
+		os.Exit(2)
+	}
+	var t *Texture
+	if !*stream {
+		t = NewTexture(rw, rh)
+	}
+	var plate *Texture
+	if *compositeUnder != "" {
+		if *post != "" || *bilateral != "" || k > 1 || *denoise > 0 || *adaptive > 0 || *stream {
+			fmt.Fprintln(os.Stderr, "-composite-under can't be combined with post-processing, -ssaa, -adaptive or -stream")
+			os.Exit(2)
+		}
+		if plate, err = loadImage(*compositeUnder); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if plate.w != w || plate.h != h {
+			fmt.Fprintf(os.Stderr, "%s is %dx%d, not %dx%d like the image\n", *compositeUnder, plate.w, plate.h, w, h)
+			os.Exit(2)
+		}
+	}
+	light := Vec3{-1.0, -3.0, 2.0}
 	eye := Vec3{0, 0, -4.0}
-	camera := Camera{eye, w, h}
-	quitChan := make(chan bool)
-	joinChan := make(chan bool)
-	jobChan := make(chan Rect)
-	renderer := Renderer{scene, t, &camera, ss, w, h, jobChan, quitChan, joinChan}
-	for w := 0; w < workers; w++ {
-		tint := Vec3{0.5, float32(w) / float32(workers), 0.5}
-		go renderer.worker(tint)
+	camera := NewCamera(eye, k*w, k*h).Overscan(k * *overscan)
+	var sp Geometry
+	if *lod > 0 {
+		// The LOD radius is in output pixels.
+		sp = createSpherePyramidLOD(camera, float32(k)*float32(*lod), level, Vec3{0.0, -1.0, 0.0}, 1.0)
+	} else {
+		sp = createSpherePyramid(level, Vec3{0.0, -1.0, 0.0}, 1.0)
 	}
-	for y := 0; y < h; y += chunkh {
-		for x := 0; x < w; x += chunkw {
-			renderer.jobChan <- Rect{x, y, x + chunkw, y + chunkh}
+	if *prune > 0 {
+		groups, leaves := countNodes(sp)
+		if pruned := Prune(sp, camera, float32(k)*float32(*prune)); pruned != nil {
+			sp = pruned
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: -prune %g leaves out all spheres, rendering just the background\n", *prune)
+			sp = NewGroup(Sphere{}, nil)
+		}
+		if *verbose {
+			pg, pl := countNodes(sp)
+			fmt.Fprintf(os.Stderr, "pruned scene from %d groups and %d leaves to %d groups and %d leaves\n", groups, leaves, pg, pl)
 		}
 	}
-	for w := 0; w < workers; w++ {
-		renderer.quitChan <- true
+	scene, err := createScene(light, sp, SolidBackground(backgroundColor))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	for w := 0; w < workers; w++ {
-		<-renderer.joinChan
+	scene.ScaleBias = *scaleBias
+	if *lightOrbit > 0 {
+		scene.LightPath = OrbitLight(light, float32(*lightOrbit))
 	}
-	od, err := os.OpenFile("out.tga", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err == nil {
-		t.WriteTGA(od)
-		od.Close()
+	if *shAmbient > 0 {
+		sh := ComputeSHCoefficients(BackgroundEnv(scene.Background), *shAmbient)
+		scene.SHCoefficients = &sh
+	}
+	scene, err = scene.AtTime(float32(*sceneTime))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *analyze {
+		st := scene.Analyze()
+		st.Print(os.Stdout)
+		return
+	}
+	renderer := NewRenderer(scene, t, camera, ss)
+	renderer.ReserveCore = *reserveCore
+	if *workers > 0 {
+		renderer.workers = *workers
+	} else {
+		renderer.AutoWorkers()
+	}
+	renderer.maxParallelism = *maxParallelism
+	// A failed checkpoint doesn't stop the render, but fails the run. Workers
+	// save checkpoints concurrently.
+	var checkpointFailed atomic.Bool
+	if *checkpointEvery > 0 {
+		renderer.OutputEveryN = *checkpointEvery
+		renderer.CheckpointSaver = func(t *Texture, completedTiles int) {
+			if err := writeTGA(fmt.Sprintf("checkpoint_%04d.tga", completedTiles / *checkpointEvery), t); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				checkpointFailed.Store(true)
+			}
+		}
+	}
+	mode, err := parseAAMode(*aaMode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	renderer.AAMode = mode
+	if renderer.TileOrder, err = parseTileOrder(*tileOrder); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	renderer.Interleave = *interleave
+	renderer.FillSnapshots = *fillCheckpoints
+	renderer.VarianceThreshold = float32(*adaptive)
+	renderer.AdaptivePasses = *adaptivePasses
+	renderer.MaxSamples = *maxSamples
+	renderer.SeedFromOutput = *seedFromOutput
+	renderer.Transparent = plate != nil
+	if outputs.wants("albedo") || *denoise > 0 {
+		renderer.albedo = NewTexture(rw, rh)
+	}
+	if outputs.wants("normal") || *denoise > 0 {
+		renderer.normal = NewTexture(rw, rh)
+	}
+	if outputs.wants("depth") {
+		renderer.depth = make([]float32, rw*rh)
+	}
+	if *gamma <= 0 {
+		fmt.Fprintln(os.Stderr, "-gamma must be positive")
+		os.Exit(2)
+	}
+	renderer.Gamma = float32(*gamma)
+	var postStages []PostStage
+	if *bilateral != "" {
+		var s, n, d float32
+		if _, err := fmt.Sscanf(*bilateral, "%g:%g:%g", &s, &n, &d); err != nil || s <= 0 || n <= 0 || d <= 0 {
+			fmt.Fprintln(os.Stderr, "invalid -bilateral:", *bilateral)
+			os.Exit(2)
+		}
+		// Denoising comes before any other post-processing.
+		postStages = append(postStages, JointBilateral(renderer, s, n, d, renderer.workers))
+		if renderer.normal == nil {
+			renderer.normal = NewTexture(rw, rh)
+		}
+		if renderer.depth == nil {
+			renderer.depth = make([]float32, rw*rh)
+		}
+	}
+	if *post != "" {
+		stages, err := ParsePost(*post, renderer.workers)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		postStages = append(postStages, stages...)
+	}
+	if postStages != nil || k > 1 {
+		renderer.hdr = NewFloatImage(rw, rh)
+	}
+	if renderer.SeedFromOutput {
+		// Any explicit -seed conflicts, including -seed 0.
+		seedSet := false
+		flag.Visit(func(f *flag.Flag) { seedSet = seedSet || f.Name == "seed" })
+		if seedSet {
+			fmt.Fprintln(os.Stderr, "-seed and -seed-from-output are exclusive")
+			os.Exit(2)
+		}
+		renderer.SeedFromFilename(outputs[0].path)
+	} else {
+		renderer.SetSeed(*seed)
+	}
+	if *verbose {
+		fmt.Fprintln(os.Stderr, "workers:", renderer.workers)
+		replay := fmt.Sprintf("-width %d -height %d -overscan %d -ssaa %d %s", w, h, *overscan, k, renderer.ReplayFlags())
+		if f := setFlags(sceneFlags); f != "" {
+			replay += " " + f
+		}
+		fmt.Fprintln(os.Stderr, "replay with:", replay)
+	}
+	if *debugPixel != "" {
+		var x, y int
+		if _, err := fmt.Sscanf(*debugPixel, "%d,%d", &x, &y); err != nil || !(Rect{0, 0, w, h}).Contains(x, y) {
+			fmt.Fprintln(os.Stderr, "invalid -debug-pixel:", *debugPixel)
+			os.Exit(2)
+		}
+		renderer.DebugPixel(x+*overscan, y+*overscan).Print()
+		return
+	}
+	if *debugRay != "" {
+		var r Ray
+		_, err := fmt.Sscanf(*debugRay, "%g,%g,%g,%g,%g,%g", &r.orig.x, &r.orig.y, &r.orig.z, &r.dir.x, &r.dir.y, &r.dir.z)
+		dir, ok := NormalizeSafe(r.dir)
+		if err != nil || !ok {
+			fmt.Fprintln(os.Stderr, "invalid -debug-ray:", *debugRay)
+			os.Exit(2)
+		}
+		r.dir = dir
+		renderer.DebugRay = &r
+	}
+	if *progress {
+		renderer.WithProgress(func(p Progress) {
+			fmt.Fprintf(os.Stderr, "\r%5.1f%% %d/%d tiles, %v remaining ", 100*p.Fraction(), p.tilesDone, p.tilesTotal, p.remaining.Round(time.Second))
+			if p.tilesDone == p.tilesTotal {
+				fmt.Fprintln(os.Stderr)
+			}
+		})
+	}
+	ctx := context.Background()
+	if *maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxTime)
+		defer cancel()
+	}
+	if *stream {
+		if len(outputs) > 1 || outputs[0].buffer != "beauty" || isPNG(outputs[0].path) || *denoise > 0 || *adaptive > 0 || *preview || *checkpointEvery > 0 || *overscan > 0 || *post != "" || *bilateral != "" {
+			fmt.Fprintln(os.Stderr, "-stream only writes the image itself, as TGA")
+			os.Exit(2)
+		}
+		od := openOutput(outputs[0].path)
+		err := od.Truncate(0)
+		if err == nil {
+			err = renderer.RenderStreamed(ctx, od)
+		}
+		if cerr := od.Close(); err == nil {
+			err = cerr
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			printTimeLimit(renderer, *maxTime, *progress)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *stats {
+			renderer.stats.Print()
+		}
+		if renderer.PrintNonFinite(os.Stderr, 0) && *strict {
+			os.Exit(1)
+		}
+		return
+	}
+	files := make([]*os.File, len(outputs))
+	for i, o := range outputs {
+		files[i] = openOutput(o.path)
+	}
+	if err := renderer.Render(ctx); errors.Is(err, context.DeadlineExceeded) {
+		printTimeLimit(renderer, *maxTime, *progress)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *stats {
+		renderer.stats.Print()
+	}
+	if k > 1 {
+		renderer.hdr = Resize(renderer.hdr, ow, oh, filter)
+	}
+	var nonFinite bool
+	if renderer.hdr != nil {
+		// Post-processing works on linear colors, so encoding comes last.
+		if err := RunPost(renderer.hdr, append(postStages, Gamma(renderer.Gamma))); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// Checked after all float stages, which may add NaNs to those the
+		// render produced.
+		n, b := renderer.hdr.NonFinite()
+		nonFinite = printNonFinite(os.Stderr, n, b, *overscan)
+		t = renderer.hdr.Texture()
+	} else {
+		nonFinite = renderer.PrintNonFinite(os.Stderr, *overscan)
+	}
+	if nonFinite && *strict {
+		os.Exit(1)
+	}
+	if *denoise > 0 {
+		t = Denoise(t, renderer.albedo, renderer.normal, *denoise)
+	}
+	buffers := map[string]*Texture{"beauty": t, "albedo": renderer.albedo, "normal": renderer.normal}
+	if renderer.depth != nil {
+		buffers["depth"] = renderer.DepthTexture()
+	}
+	if *overscan > 0 {
+		frame := Rect{*overscan, *overscan, *overscan + w, *overscan + h}
+		for name, b := range buffers {
+			if b != nil {
+				buffers[name] = b.Crop(frame)
+			}
+		}
+		t = buffers["beauty"]
+	}
+	if plate != nil {
+		if t, err = Composite(plate, t); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		buffers["beauty"] = t
+	}
+	for i, o := range outputs {
+		mustSaveImage(files[i], buffers[o.buffer])
+	}
+	if *preview {
+		previewTerm(t)
+	}
+	if checkpointFailed.Load() {
+		os.Exit(1)
 	}
 }
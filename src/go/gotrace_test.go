@@ -0,0 +1,1226 @@
+package main
+
+import bytes "bytes"
+import context "context"
+import errors "errors"
+import io "io"
+import math "math"
+import rand "math/rand"
+import os "os"
+import exec "os/exec"
+import filepath "path/filepath"
+import runtime "runtime"
+import slices "slices"
+import strings "strings"
+import sync "sync"
+import atomic "sync/atomic"
+import testing "testing"
+
+// TestMain runs main instead of the tests when the test binary is started by
+// runMain, so the command line can be tested including its exit status.
+func TestMain(m *testing.M) {
+	if os.Getenv("GOTRACE_RUN_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the program with args in dir, returning its exit status and
+// everything it wrote to stderr.
+func runMain(t *testing.T, dir string, args ...string) (int, string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOTRACE_RUN_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode(), string(out)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0, string(out)
+}
+
+func TestUnwritableOutputFails(t *testing.T) {
+	dir := t.TempDir()
+	code, out := runMain(t, dir, "-width", "32", "-height", "24", "-o", filepath.Join(dir, "missing", "out.tga"))
+	if code == 0 {
+		t.Fatalf("run with an unwritable output succeeded: %s", out)
+	}
+}
+
+func TestFailedCheckpointFailsRun(t *testing.T) {
+	dir := t.TempDir()
+	// A directory in place of the first checkpoint can't be written to.
+	if err := os.Mkdir(filepath.Join(dir, "checkpoint_0001.tga"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	code, out := runMain(t, dir, "-width", "32", "-height", "24", "-workers", "4", "-checkpoint-every", "1")
+	if code == 0 {
+		t.Fatalf("run with a failing checkpoint succeeded: %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.tga")); err != nil {
+		t.Errorf("output not written after a failed checkpoint: %v", err)
+	}
+}
+
+// pyramidRenderer returns a renderer for the default scene with a pyramid
+// of the given level, at w x h pixels with ss x ss samples per pixel.
+// mustScene is createScene for the fixed lights of tests, which all have a
+// direction.
+func mustScene(light Vec3, g Geometry, background func(r *Ray) Vec3) *Scene {
+	scene, err := createScene(light, g, background)
+	if err != nil {
+		panic(err)
+	}
+	return scene
+}
+
+func pyramidRenderer(w, h, level, ss int) *Renderer {
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, createSpherePyramid(level, Vec3{0.0, -1.0, 0.0}, 1.0), SolidBackground(backgroundColor))
+	ren := NewRenderer(scene, NewTexture(w, h), NewCamera(Vec3{0, 0, -4.0}, w, h), ss)
+	ren.workers = 2
+	return ren
+}
+
+// mustRender renders ren, failing t if that fails.
+func mustRender(t *testing.T, ren *Renderer) *Texture {
+	t.Helper()
+	if err := ren.Render(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return ren.t
+}
+
+func TestPartialTilesCoverImageOnce(t *testing.T) {
+	ren := pyramidRenderer(100, 70, 3, 1)
+	count := make([]int, 100*70)
+	for _, r := range ren.tiles(0, ren.yres) {
+		ren.checkTile(r)
+		r.Each(func(x, y int) { count[y*100+x]++ })
+	}
+	for i, n := range count {
+		if n != 1 {
+			t.Fatalf("pixel %d, %d is in %d tiles", i%100, i/100, n)
+		}
+	}
+	mustRender(t, ren)
+	if ren.stats.pixels != 100*70 {
+		t.Errorf("rendered %d pixels, want %d", ren.stats.pixels, 100*70)
+	}
+	for i := 3; i < len(ren.t.buf); i += 4 {
+		if ren.t.buf[i] != 255 {
+			t.Fatalf("pixel %d, %d was not written", i/4%100, i/4/100)
+		}
+	}
+}
+
+func TestCenterFirstStartsAtCenter(t *testing.T) {
+	for _, size := range [][2]int{{100, 70}, {64, 64}, {33, 17}, {200, 9}} {
+		ren := pyramidRenderer(size[0], size[1], 3, 1)
+		ren.TileOrder = TileCenterFirst
+		tiles := ren.tiles(0, ren.yres)
+		// The center is a point between pixels for even sizes, which may be on
+		// the corner of several tiles, so the edges count.
+		first := tiles[0]
+		if 2*first.l > size[0] || 2*first.r < size[0] || 2*first.t > size[1] || 2*first.b < size[1] {
+			t.Errorf("%dx%d: first tile %v doesn't contain the center", size[0], size[1], first)
+		}
+		if len(tiles) != len(pyramidRenderer(size[0], size[1], 3, 1).tiles(0, ren.yres)) {
+			t.Errorf("%dx%d: center first order has %d tiles, not as many as scanline order", size[0], size[1], len(tiles))
+		}
+	}
+}
+
+func TestNonPositiveSizeRejected(t *testing.T) {
+	for _, size := range [][]string{{"-width", "-5"}, {"-width", "0", "-height", "0"}, {"-height", "0"}} {
+		if code, out := runMain(t, t.TempDir(), size...); code != 2 {
+			t.Errorf("%v exited with %d, want 2: %s", size, code, out)
+		}
+	}
+}
+
+func TestInterleavedTilesCoverRowsOnce(t *testing.T) {
+	for _, c := range []struct {
+		k, y0, y1 int
+		want      []int
+	}{
+		{3, 0, 10, []int{0, 3, 6, 9, 1, 4, 7, 2, 5, 8}},
+		{2, 4, 9, []int{4, 6, 8, 5, 7}},
+		{1, 0, 3, []int{0, 1, 2}},
+		{12, 0, 4, []int{0, 1, 2, 3}},
+	} {
+		ren := pyramidRenderer(20, 10, 3, 1)
+		ren.TileOrder = TileInterleaved
+		ren.Interleave = c.k
+		var rows []int
+		for _, r := range ren.tiles(c.y0, c.y1) {
+			if r.l != 0 || r.r != 20 || r.Height() != 1 {
+				t.Errorf("interleave %d: tile %v isn't a single full row", c.k, r)
+			}
+			rows = append(rows, r.t)
+		}
+		if !slices.Equal(rows, c.want) {
+			t.Errorf("interleave %d of rows %d to %d renders rows %v, want %v", c.k, c.y0, c.y1, rows, c.want)
+		}
+	}
+}
+
+func TestFillRowsCopiesNearestDoneRow(t *testing.T) {
+	tex := NewTexture(2, 7)
+	for y := 0; y < 7; y++ {
+		tex.SetRgba(0, y, byte(y), 0, 0, 255)
+		tex.SetRgba(1, y, byte(y), 1, 0, 255)
+	}
+	// Rows 3 and 5 are done. Row 4 is as near to both, and takes the one
+	// above.
+	done := []bool{false, false, false, true, false, true, false}
+	fillRows(tex, done)
+	for y, want := range []byte{3, 3, 3, 3, 3, 5, 5} {
+		for x := 0; x < 2; x++ {
+			if r, g, _, _ := tex.GetRgba(x, y); r != want || g != byte(x) {
+				t.Errorf("pixel %d, %d is from row %d, column %d, want row %d", x, y, r, g, want)
+			}
+		}
+	}
+	// Without any row done there's nothing to copy.
+	empty := asymmetricTexture(3, 4)
+	want := empty.Copy()
+	fillRows(empty, make([]bool, 4))
+	if !bytes.Equal(empty.buf, want.buf) {
+		t.Error("fillRows changed a texture without any row done")
+	}
+}
+
+func TestPrimaryRaysStartAtEye(t *testing.T) {
+	// The camera is a pinhole, so supersampling only spreads the directions
+	// of the ss*ss primary rays, never their origin.
+	for _, mode := range []AAMode{AAGrid, AAJitter, AAHalton} {
+		for ss := 1; ss <= 4; ss++ {
+			ren := pyramidRenderer(40, 30, 3, ss)
+			ren.AAMode = mode
+			dirs := map[Vec3]bool{}
+			for _, e := range ren.DebugPixel(20, 12).events {
+				if e.kind != "primary" {
+					continue
+				}
+				if e.ray.orig != ren.cam.eye {
+					t.Errorf("mode %d, ss %d: primary ray starts at %v, not the eye", mode, ss, e.ray.orig)
+				}
+				dirs[e.ray.dir] = true
+			}
+			if len(dirs) != ss*ss {
+				t.Errorf("mode %d, ss %d: %d different primary rays, want %d", mode, ss, len(dirs), ss*ss)
+			}
+		}
+	}
+}
+
+func TestDebugPixelMatchesJitteredRender(t *testing.T) {
+	for _, order := range []TileOrder{TileScanline, TileInterleaved} {
+		ren := pyramidRenderer(40, 30, 3, 2)
+		ren.AAMode = AAJitter
+		ren.TileOrder = order
+		ren.seed = 7
+		img := mustRender(t, ren)
+		for _, p := range [][2]int{{0, 0}, {5, 3}, {15, 15}, {16, 16}, {39, 29}, {20, 12}} {
+			pt := ren.DebugPixel(p[0], p[1])
+			r, g, b, _ := img.GetRgba(p[0], p[1])
+			if got := [3]byte{f2b(pt.color.x), f2b(pt.color.y), f2b(pt.color.z)}; got != [3]byte{r, g, b} {
+				t.Errorf("tile order %d: pixel %v probed as %v, rendered as %v", order, p, got, [3]byte{r, g, b})
+			}
+		}
+	}
+}
+
+func TestLoggedSeedReplaysImage(t *testing.T) {
+	dir := t.TempDir()
+	code, out := runMain(t, dir, "-width", "48", "-height", "32", "-aa", "jitter", "-seed-from-output",
+		"-tile-order", "interleaved", "-interleave", "3", "-verbose", "-o", "first.tga")
+	if code != 0 {
+		t.Fatalf("render failed: %s", out)
+	}
+	var replay []string
+	for _, l := range strings.Split(out, "\n") {
+		if f, ok := strings.CutPrefix(l, "replay with: "); ok {
+			replay = strings.Fields(f)
+		}
+	}
+	if replay == nil {
+		t.Fatalf("no replay flags logged: %s", out)
+	}
+	if code, out := runMain(t, dir, append(replay, "-o", "second.tga")...); code != 0 {
+		t.Fatalf("replay %v failed: %s", replay, out)
+	}
+	a, err := os.ReadFile(filepath.Join(dir, "first.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "second.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("replaying with %v gave a different image", replay)
+	}
+}
+
+// approx reports whether a and b differ by at most eps.
+func approx(a, b, eps float32) bool {
+	return a-b <= eps && b-a <= eps
+}
+
+func TestSphereAllHitsThroughCenter(t *testing.T) {
+	s := &Sphere{Vec3{1, 2, 3}, 1.5}
+	r := Ray{Vec3{1, 2, -7}, Vec3{0, 0, 1}}
+	hits := s.AllHits(&r)
+	if len(hits) != 2 {
+		t.Fatalf("got %d hits, want 2", len(hits))
+	}
+	if d := hits[1].distance - hits[0].distance; !approx(d, 2*s.radius, 1e-5) {
+		t.Errorf("hits are %g apart, want the diameter %g", d, 2*s.radius)
+	}
+	if hits[0].distance > hits[1].distance {
+		t.Errorf("hits at %g and %g aren't sorted", hits[0].distance, hits[1].distance)
+	}
+}
+
+func TestMaxTimeEndsProgressLine(t *testing.T) {
+	code, out := runMain(t, t.TempDir(), "-progress", "-max-time", "50ms")
+	if code != 0 {
+		t.Fatalf("render failed: %s", out)
+	}
+	i := strings.Index(out, "stopped after")
+	if i < 1 {
+		t.Fatalf("no time limit reported: %q", out)
+	}
+	if out[i-1] != '\n' {
+		t.Errorf("time limit report continues the progress line: %q", out[max(i-40, 0):])
+	}
+}
+
+func TestSeedFromString(t *testing.T) {
+	a, b := SeedFromString("frame0001.png"), SeedFromString("frame0002.png")
+	if a == b {
+		t.Errorf("frame0001.png and frame0002.png both have the seed %d", a)
+	}
+	if again := SeedFromString("frame0001.png"); again != a {
+		t.Errorf("seed of frame0001.png changed from %d to %d", a, again)
+	}
+}
+
+func TestSeedFromFilenameChangesJitter(t *testing.T) {
+	render := func(path string) *Texture {
+		ren := pyramidRenderer(32, 24, 3, 2)
+		ren.AAMode = AAJitter
+		ren.SeedFromFilename(path)
+		return mustRender(t, ren)
+	}
+	a, b := render("frame0001.png"), render("frame0002.png")
+	if bytes.Equal(a.buf, b.buf) {
+		t.Errorf("frames with different filenames were jittered the same")
+	}
+	if again := render("frame0001.png"); !bytes.Equal(a.buf, again.buf) {
+		t.Errorf("rendering frame0001.png twice gave different images")
+	}
+}
+
+func TestDebugPixelTracesLitPixel(t *testing.T) {
+	ren := pyramidRenderer(40, 30, 3, 1)
+	// On the front of the top sphere, facing the light.
+	pt := ren.DebugPixel(20, 27)
+	if len(pt.events) != 2 {
+		t.Fatalf("traced %d rays, want a primary and a shadow ray: %+v", len(pt.events), pt.events)
+	}
+	if e := pt.events[0]; e.kind != "primary" || !e.hit || e.geom == nil {
+		t.Errorf("primary ray %+v didn't hit the pyramid", e)
+	}
+	if e := pt.events[1]; e.kind != "shadow" || e.hit {
+		t.Errorf("shadow ray %+v was blocked", e)
+	}
+	if pt.color.y <= ambientSphereColor.y {
+		t.Errorf("pixel color %v isn't lit above the ambient %v", pt.color, ambientSphereColor)
+	}
+}
+
+func TestAutoWorkersIsPositive(t *testing.T) {
+	ren := pyramidRenderer(8, 8, 1, 1)
+	if got := ren.AutoWorkers(); got != ren {
+		t.Errorf("AutoWorkers returned another renderer")
+	}
+	if n := runtime.GOMAXPROCS(0); ren.workers != n {
+		t.Errorf("AutoWorkers picked %d workers, want GOMAXPROCS %d", ren.workers, n)
+	}
+	ren.ReserveCore = true
+	if ren.AutoWorkers(); ren.workers < 1 || ren.workers != max(1, runtime.GOMAXPROCS(0)-1) {
+		t.Errorf("AutoWorkers reserving a core picked %d workers", ren.workers)
+	}
+	// Even with a single CPU there is a worker.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	if ren.AutoWorkers(); ren.workers != 1 {
+		t.Errorf("AutoWorkers reserving the only core picked %d workers", ren.workers)
+	}
+}
+
+func TestAlbedoBufferIsUnshaded(t *testing.T) {
+	ren := pyramidRenderer(40, 30, 1, 1)
+	ren.albedo = NewTexture(40, 30)
+	ren.depth = make([]float32, 40*30)
+	img := mustRender(t, ren)
+	want := [3]byte{f2b(diffuseSphereColor.x), f2b(diffuseSphereColor.y), f2b(diffuseSphereColor.z)}
+	shades := map[[3]byte]bool{}
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			if ren.depth[y*40+x] == infinity {
+				continue
+			}
+			r, g, b, _ := ren.albedo.GetRgba(x, y)
+			if got := [3]byte{r, g, b}; got != want {
+				t.Fatalf("albedo of the sphere at %d, %d is %v, want its diffuse color %v", x, y, got, want)
+			}
+			r, g, b, _ = img.GetRgba(x, y)
+			shades[[3]byte{r, g, b}] = true
+		}
+	}
+	if len(shades) < 10 {
+		t.Errorf("the beauty buffer has %d shades of the sphere, want it shaded", len(shades))
+	}
+}
+
+// renderHDR renders ren and returns its unclamped colors.
+func renderHDR(t *testing.T, ren *Renderer) *FloatImage {
+	t.Helper()
+	ren.hdr = NewFloatImage(ren.xres, ren.yres)
+	mustRender(t, ren)
+	return ren.hdr
+}
+
+func TestBrightLightKeepsFullValueInHDR(t *testing.T) {
+	// A background of 10 lights the sphere with an ambient of 0.7*10, on top
+	// of which the light adds up to another 0.7.
+	const w, h = 40, 30
+	bg := SolidBackground(Vec3{10, 10, 10})
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, &Sphere{Vec3{0, 0, 0}, 1}, bg)
+	sh := ComputeSHCoefficients(BackgroundEnv(bg), 1000)
+	scene.SHCoefficients = &sh
+	ren := NewRenderer(scene, NewTexture(w, h), NewCamera(Vec3{0, 0, -4}, w, h), 1)
+	hdr := renderHDR(t, ren)
+	if c := hdr.At(0, 0); c != (Vec3{10, 10, 10}) {
+		t.Errorf("background is %v in the float buffer, want 10", c)
+	}
+	if c := hdr.At(w/2, h/2); c.y < 6.9 || c.y > 7.8 {
+		t.Errorf("sphere is %v in the float buffer, want green between 7 and 7.7", c)
+	}
+	if _, g, _, _ := ren.t.GetRgba(w/2, h/2); g != 255 {
+		t.Errorf("sphere is encoded as green %d, want it clamped to 255", g)
+	}
+}
+
+func TestRenderGamma(t *testing.T) {
+	ren := pyramidRenderer(48, 32, 3, 2)
+	ren.Gamma = 1
+	linear := renderHDR(t, ren)
+	plain := ren.t.Copy()
+	for i, c := range linear.pix {
+		x, y := i%linear.w, i/linear.w
+		if r, g, b, _ := plain.GetRgba(x, y); r != f2b(c.x) || g != f2b(c.y) || b != f2b(c.z) {
+			t.Fatalf("gamma 1 encoded pixel %d, %d as %d, %d, %d, not f2b of %v", x, y, r, g, b, c)
+		}
+	}
+
+	ren = pyramidRenderer(48, 32, 3, 2)
+	ren.Gamma = 2.2
+	mustRender(t, ren)
+	midTones := 0
+	for i, c := range linear.pix {
+		x, y := i%linear.w, i/linear.w
+		_, g, _, _ := ren.t.GetRgba(x, y)
+		if want := f2b(float32(math.Pow(float64(c.y), 1/2.2))); g != want {
+			t.Fatalf("gamma 2.2 encoded green %g at %d, %d as %d, want %d", c.y, x, y, g, want)
+		}
+		if c.y > 0.1 && c.y < 0.9 {
+			midTones++
+			if _, g0, _, _ := plain.GetRgba(x, y); g <= g0 {
+				t.Errorf("gamma 2.2 didn't brighten green %d at %d, %d", g0, x, y)
+			}
+		}
+	}
+	if midTones == 0 {
+		t.Error("image has no mid-tones")
+	}
+	if v := gammaEncode(Vec3{0.5, 0.5, 0.5}, 2.2); !approx(v.x, 0.7297, 1e-4) {
+		t.Errorf("gamma 2.2 encodes 0.5 as %g, want 0.7297", v.x)
+	}
+}
+
+// squaredError returns the summed squared difference of a and b.
+func squaredError(a, b *FloatImage) float64 {
+	var e float64
+	for i := range a.pix {
+		d := vec3sub(a.pix[i], b.pix[i])
+		e += float64(vec3dot(d, d))
+	}
+	return e
+}
+
+func TestJitteredPassesBeatSingleGridSample(t *testing.T) {
+	ref := renderHDR(t, pyramidRenderer(40, 30, 3, 8))
+	grid := renderHDR(t, pyramidRenderer(40, 30, 3, 1))
+	acc := NewFloatImage(40, 30)
+	const passes = 16
+	for pass := 0; pass < passes; pass++ {
+		ren := pyramidRenderer(40, 30, 3, 1)
+		ren.AAMode = AAJitter
+		ren.SetSeed(int64(pass))
+		for i, v := range renderHDR(t, ren).pix {
+			acc.pix[i] = vec3add(acc.pix[i], vec3mulf(v, 1.0/passes))
+		}
+	}
+	if g, j := squaredError(grid, ref), squaredError(acc, ref); j >= g {
+		t.Errorf("%d jittered passes have a squared error of %g, not below the %g of a single grid sample", passes, j, g)
+	}
+}
+
+func TestGenerateJitteredRayStaysInPixel(t *testing.T) {
+	cam := NewCamera(Vec3{0, 0, -4}, 40, 30)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		r := cam.GenerateJitteredRay(7, 5, rng)
+		// Scale the direction back onto the image plane.
+		x := r.dir.x/r.dir.z*cam.focal + 20
+		y := r.dir.y/r.dir.z*cam.focal + 15
+		if x < 7-1e-4 || x > 8+1e-4 || y < 5-1e-4 || y > 6+1e-4 {
+			t.Fatalf("jittered ray through %g, %g left the pixel 7, 5", x, y)
+		}
+	}
+}
+
+func TestStatsReportAverageSamples(t *testing.T) {
+	uniform := pyramidRenderer(40, 30, 1, 2)
+	mustRender(t, uniform)
+	if avg := uniform.stats.AvgSamples(); avg != 4 {
+		t.Errorf("uniform 2x2 sampling averaged %g samples per pixel, want 4", avg)
+	}
+	// The lone sphere leaves most of the image flat background.
+	adaptive := pyramidRenderer(40, 30, 1, 2)
+	adaptive.VarianceThreshold = 1e-6
+	adaptive.AdaptivePasses = 3
+	adaptive.MaxSamples = 16
+	mustRender(t, adaptive)
+	if avg := adaptive.stats.AvgSamples(); avg <= 4 || avg >= 16 {
+		t.Errorf("adaptive sampling averaged %g samples per pixel, want more than 4 and less than the 16 of uniform sampling at its budget", avg)
+	}
+}
+
+func TestRectOverlapIntersectUnion(t *testing.T) {
+	a := Rect{0, 0, 10, 10}
+	for _, c := range []struct {
+		name    string
+		b       Rect
+		overlap bool
+		inter   Rect
+		union   Rect
+	}{
+		{"apart", Rect{20, 20, 30, 30}, false, Rect{}, Rect{0, 0, 30, 30}},
+		{"touching", Rect{10, 0, 20, 10}, false, Rect{}, Rect{0, 0, 20, 10}},
+		{"contained", Rect{2, 3, 5, 7}, true, Rect{2, 3, 5, 7}, a},
+		{"partial", Rect{5, -5, 15, 5}, true, Rect{5, 0, 10, 5}, Rect{0, -5, 15, 10}},
+	} {
+		if got := a.Overlap(c.b); got != c.overlap {
+			t.Errorf("%s: Overlap = %v, want %v", c.name, got, c.overlap)
+		}
+		if got, ok := a.Intersect(c.b); got != c.inter || ok != c.overlap {
+			t.Errorf("%s: Intersect = %v, %v, want %v, %v", c.name, got, ok, c.inter, c.overlap)
+		}
+		if got := a.Union(c.b); got != c.union {
+			t.Errorf("%s: Union = %v, want %v", c.name, got, c.union)
+		}
+		if a.Overlap(c.b) != c.b.Overlap(a) || a.Union(c.b) != c.b.Union(a) {
+			t.Errorf("%s: Overlap or Union isn't symmetric", c.name)
+		}
+	}
+	if n := (Rect{2, 3, 5, 7}).Area(); n != 12 {
+		t.Errorf("Area = %d, want 12", n)
+	}
+}
+
+func TestRectSizesAndSplits(t *testing.T) {
+	for _, c := range []struct {
+		r    Rect
+		w, h int
+	}{
+		{Rect{1, 2, 6, 5}, 5, 3},
+		{Rect{-3, -2, 4, 0}, 7, 2},
+		{Rect{4, 4, 5, 5}, 1, 1},
+		{Rect{3, 3, 3, 8}, 0, 5},
+		{Rect{5, 5, 2, 1}, 0, 0},
+		{Rect{}, 0, 0},
+	} {
+		r := c.r
+		if r.Width() != c.w || r.Height() != c.h || r.Area() != c.w*c.h {
+			t.Errorf("%v is %dx%d with an area of %d, want %dx%d", r, r.Width(), r.Height(), r.Area(), c.w, c.h)
+		}
+		// Each visits exactly the pixels Contains reports, in rows from the
+		// top-left.
+		var pixels [][2]int
+		r.Each(func(x, y int) {
+			if !r.Contains(x, y) {
+				t.Errorf("%v: Each visited %d, %d which it doesn't contain", r, x, y)
+			}
+			pixels = append(pixels, [2]int{x, y})
+		})
+		if len(pixels) != r.Area() {
+			t.Errorf("%v: Each visited %d pixels, want %d", r, len(pixels), r.Area())
+		}
+		for i := 1; i < len(pixels); i++ {
+			p, q := pixels[i-1], pixels[i]
+			if q[1] < p[1] || q[1] == p[1] && q[0] <= p[0] {
+				t.Errorf("%v: Each visited %v after %v", r, q, p)
+			}
+		}
+		for _, p := range [][2]int{{r.l - 1, r.t}, {r.l, r.t - 1}, {r.r, r.t}, {r.l, r.b}, {r.r - 1, r.b - 1}} {
+			want := r.Area() > 0 && p == [2]int{r.r - 1, r.b - 1}
+			if got := r.Contains(p[0], p[1]); got != want {
+				t.Errorf("%v: Contains(%d, %d) = %v, want %v", r, p[0], p[1], got, want)
+			}
+		}
+
+		top, bottom := r.SplitH()
+		left, right := r.SplitV()
+		for _, halves := range [][2]Rect{{top, bottom}, {left, right}} {
+			a, b := halves[0], halves[1]
+			if a.Area()+b.Area() != r.Area() || a.Overlap(b) || a.Area() > b.Area() {
+				t.Errorf("%v split into %v and %v", r, a, b)
+			}
+			r.Each(func(x, y int) {
+				if a.Contains(x, y) == b.Contains(x, y) {
+					t.Errorf("%v: %d, %d isn't in exactly one of %v and %v", r, x, y, a, b)
+				}
+			})
+		}
+		if top.Height() != c.h/2 || left.Width() != c.w/2 {
+			t.Errorf("%v: top half %v or left half %v don't get the smaller part", r, top, left)
+		}
+	}
+}
+
+func TestStreamedRenderMatchesInMemory(t *testing.T) {
+	for _, mode := range []AAMode{AAGrid, AAJitter} {
+		// Neither side is a multiple of the tile size.
+		ren := pyramidRenderer(50, 37, 3, 2)
+		ren.AAMode = mode
+		var want bytes.Buffer
+		if err := mustRender(t, ren).WriteTGA(&want); err != nil {
+			t.Fatal(err)
+		}
+		streamed := pyramidRenderer(50, 37, 3, 2)
+		streamed.t = nil
+		streamed.AAMode = mode
+		var got bytes.Buffer
+		if err := streamed.RenderStreamed(context.Background(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("AA mode %v: streamed TGA differs from the in-memory render", mode)
+		}
+	}
+}
+
+func TestStreamFlagMatchesInMemoryOutput(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{{"-o", "memory.tga"}, {"-stream", "-o", "streamed.tga"}} {
+		if code, out := runMain(t, dir, append([]string{"-width", "40", "-height", "30"}, args...)...); code != 0 {
+			t.Fatalf("%v failed: %s", args, out)
+		}
+	}
+	a, err := os.ReadFile(filepath.Join(dir, "memory.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "streamed.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("-stream wrote a different file")
+	}
+}
+
+func TestStreamRejectsOverscanAndSSAA(t *testing.T) {
+	for _, args := range [][]string{{"-overscan", "2"}, {"-ssaa", "2"}} {
+		args = append([]string{"-width", "40", "-height", "30", "-stream"}, args...)
+		if code, out := runMain(t, t.TempDir(), args...); code != 2 {
+			t.Errorf("%v exited with %d, want 2: %s", args, code, out)
+		}
+	}
+}
+
+func TestWideImageKeepsSpheresRound(t *testing.T) {
+	const w, h = 1024, 512
+	// Small enough not to be cut off at the top and bottom.
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, &Sphere{Vec3{0, 0, 8}, 1}, SolidBackground(backgroundColor))
+	ren := NewRenderer(scene, NewTexture(w, h), NewCamera(Vec3{0, 0, -4}, w, h), 1)
+	ren.depth = make([]float32, w*h)
+	mustRender(t, ren)
+	var across, down int
+	for x := 0; x < w; x++ {
+		if ren.depth[h/2*w+x] != infinity {
+			across++
+		}
+	}
+	for y := 0; y < h; y++ {
+		if ren.depth[y*w+w/2] != infinity {
+			down++
+		}
+	}
+	if across == 0 || across < down-1 || across > down+1 {
+		t.Errorf("sphere is %d pixels wide and %d high", across, down)
+	}
+}
+
+func TestScaleBiasAvoidsAcneFarAway(t *testing.T) {
+	near := &Sphere{Vec3{-1.5, 0, 0}, 1}
+	far := &Sphere{Vec3{1.5e3, 0, 1e4}, 1e3}
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, NewGroup(Sphere{Vec3{}, 1e5}, []Geometry{near, far}), SolidBackground(backgroundColor))
+	cam := NewCamera(Vec3{0, 0, -4}, 200, 100)
+	// acne counts the pixels facing the light but shaded as if in shadow,
+	// which can only be self-shadowing as neither sphere is in front of the
+	// other.
+	acne := func() map[Geometry]int {
+		n := map[Geometry]int{}
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				r := cam.RayFor(float32(x)+0.5, float32(y)+0.5)
+				var h Hit
+				c := scene.trace(&r, &h, nil)
+				if h.distance < infinity && vec3dot(h.pos, scene.light) < 0 && c == scene.ambient(h.pos) {
+					n[h.geom]++
+				}
+			}
+		}
+		return n
+	}
+	if n := acne(); n[near] != 0 || n[far] < 10 {
+		t.Errorf("with a fixed bias %d near and %d far pixels have acne, want only far ones", n[near], n[far])
+	}
+	scene.ScaleBias = true
+	if n := acne(); n[near] != 0 || n[far] != 0 {
+		t.Errorf("with a scaled bias %d near and %d far pixels have acne", n[near], n[far])
+	}
+}
+
+func TestGroupHitReportsSphere(t *testing.T) {
+	left, right := &Sphere{Vec3{-2, 0, 0}, 1}, &Sphere{Vec3{2, 0, 5}, 1}
+	g := NewGroup(Sphere{Vec3{0, 0, 2.5}, 10}, []Geometry{left, right})
+	for _, c := range []struct {
+		r    Ray
+		want Geometry
+	}{
+		{Ray{Vec3{-2, 0, -4}, Vec3{0, 0, 1}}, left},
+		{Ray{Vec3{2, 0, -4}, Vec3{0, 0, 1}}, right},
+		// Through both, where the nearer one counts, whichever child it is.
+		{Ray{Vec3{-6, 0, -5}, normalize(Vec3{4, 0, 5})}, left},
+		{Ray{Vec3{6, 0, 10}, normalize(Vec3{-4, 0, -5})}, right},
+		{Ray{Vec3{0, 0, -4}, Vec3{0, 0, 1}}, nil},
+	} {
+		h := hitinfinity
+		g.Intersect(&h, &c.r)
+		if h.geom != c.want {
+			t.Errorf("ray %v hit %v, want %v", c.r, h.geom, c.want)
+		}
+	}
+}
+
+func TestWorldToScreen(t *testing.T) {
+	cam := NewCamera(Vec3{1, 2, -4}, 64, 48)
+	// The camera looks along +z.
+	forward := Vec3{0, 0, 1}
+	if x, y, ok := WorldToScreen(vec3add(cam.eye, vec3mulf(forward, 4)), cam); !ok || x != 32 || y != 24 {
+		t.Errorf("eye+forward*4 projects to %g, %g, %v, want the center 32, 24", x, y, ok)
+	}
+	// RayFor counts rows from the bottom, image space from the top.
+	for _, p := range [][2]float32{{0, 0}, {10.5, 3.25}, {64, 48}, {-5, 60}} {
+		r := cam.RayFor(p[0], p[1])
+		x, y, ok := WorldToScreen(r.At(7.5), cam)
+		if !ok || !approx(x, p[0], 1e-3) || !approx(y, 48-p[1], 1e-3) {
+			t.Errorf("point on the ray through %v projects to %g, %g, %v", p, x, y, ok)
+		}
+	}
+	for _, p := range []Vec3{cam.eye, vec3sub(cam.eye, forward), {5, -3, -4}} {
+		if _, _, ok := WorldToScreen(p, cam); ok {
+			t.Errorf("%v is in front of the camera at %v", p, cam.eye)
+		}
+	}
+}
+
+func TestCameraInsideSphereSeesInterior(t *testing.T) {
+	eye := Vec3{0, 0, -4}
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, &Sphere{Vec3{0, 0, 0}, 10}, SolidBackground(backgroundColor))
+	ren := NewRenderer(scene, NewTexture(40, 30), NewCamera(eye, 40, 30), 1)
+	ren.depth = make([]float32, 40*30)
+	ren.normal = NewTexture(40, 30)
+	img := mustRender(t, ren)
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			if ren.depth[y*40+x] == infinity {
+				t.Fatalf("pixel %d, %d missed the sphere around the camera", x, y)
+			}
+			// The sphere shadows its own interior from the light.
+			if c := img.GetV(x, y); c.y < ambientSphereColor.y-0.01 {
+				t.Fatalf("pixel %d, %d is %v, darker than the ambient color", x, y, c)
+			}
+			// Normals of the interior point back towards the camera.
+			if n := ren.normal.GetV(x, y); n.z > 0.5 {
+				t.Fatalf("normal at %d, %d faces away from the camera: %v", x, y, n)
+			}
+		}
+	}
+}
+
+// concurrencyProbe is geometry which records how many workers intersect it
+// at the same time. Each worker traces one ray at a time, so that is the
+// amount of tiles rendered concurrently.
+type concurrencyProbe struct {
+	Geometry
+	active, peak int32
+}
+
+func (p *concurrencyProbe) Intersect(h *Hit, r *Ray) {
+	n := atomic.AddInt32(&p.active, 1)
+	for {
+		peak := atomic.LoadInt32(&p.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&p.peak, peak, n) {
+			break
+		}
+	}
+	// Give other workers a chance to overlap with this one.
+	runtime.Gosched()
+	p.Geometry.Intersect(h, r)
+	atomic.AddInt32(&p.active, -1)
+}
+
+func TestMaxParallelismCapsConcurrentTiles(t *testing.T) {
+	ren := pyramidRenderer(64, 48, 2, 1)
+	probe := &concurrencyProbe{Geometry: ren.scene.g}
+	ren.scene.g = probe
+	ren.workers = 8
+	ren.maxParallelism = 2
+	mustRender(t, ren)
+	if probe.peak > 2 {
+		t.Errorf("%d tiles were rendered at the same time, want at most 2", probe.peak)
+	}
+	if ren.stats.pixels != 64*48 {
+		t.Errorf("rendered %d pixels, want %d", ren.stats.pixels, 64*48)
+	}
+}
+
+// raySphere64 returns the nearest distance along r to s in front of its
+// origin, computed in float64, or +Inf if there is none.
+func raySphere64(s *Sphere, r *Ray) float64 {
+	ox, oy, oz := float64(s.center.x-r.orig.x), float64(s.center.y-r.orig.y), float64(s.center.z-r.orig.z)
+	dx, dy, dz := float64(r.dir.x), float64(r.dir.y), float64(r.dir.z)
+	dd := dx*dx + dy*dy + dz*dz
+	b := (ox*dx + oy*dy + oz*dz) / dd
+	disc := b*b - (ox*ox+oy*oy+oz*oz-float64(s.radius)*float64(s.radius))/dd
+	if disc < 0 {
+		return math.Inf(1)
+	}
+	if t := b - math.Sqrt(disc); t > 0 {
+		return t
+	}
+	return b + math.Sqrt(disc)
+}
+
+func TestRaySphereAtLargeScale(t *testing.T) {
+	s := &Sphere{Vec3{3e3, -2e3, 2e4}, 1e3}
+	// A scanline sweeping across the silhouette of the sphere.
+	for i := 0; i <= 2000; i++ {
+		x := float32(1.9e3 + float64(i)*0.2)
+		r := Ray{Vec3{}, normalize(Vec3{x, -2e3, 2e4})}
+		want := raySphere64(s, &r)
+		got := s.RaySphere(&r)
+		if math.IsInf(want, 1) {
+			continue
+		}
+		if got == infinity {
+			t.Fatalf("ray towards x = %g missed, but hits at %g", x, want)
+		}
+		if math.Abs(float64(got)-want) > 1e-3*want {
+			t.Fatalf("ray towards x = %g hits at %g, want %g", x, got, want)
+		}
+	}
+}
+
+func TestRayForCenterAndCorners(t *testing.T) {
+	cam := NewCamera(Vec3{1, 2, -4}, 40, 30)
+	if r := cam.RayFor(20, 15); r.orig != cam.eye || r.dir != (Vec3{0, 0, 1}) {
+		t.Errorf("center ray is %+v, want one from the eye straight ahead", r)
+	}
+	bl, br := cam.RayFor(0, 0), cam.RayFor(40, 0)
+	tl, tr := cam.RayFor(0, 30), cam.RayFor(40, 30)
+	if bl.dir.x >= 0 || bl.dir.y >= 0 || tr.dir.x <= 0 || tr.dir.y <= 0 {
+		t.Errorf("corner rays %v and %v don't fan out to the bottom-left and top-right", bl.dir, tr.dir)
+	}
+	for _, p := range [][2]Ray{{bl, tr}, {br, tl}} {
+		a, b := p[0].dir, p[1].dir
+		if a.x != -b.x || a.y != -b.y || a.z != b.z {
+			t.Errorf("opposite corner rays %v and %v aren't symmetric", a, b)
+		}
+	}
+	if bl.dir.x != tl.dir.x || bl.dir.y != -tl.dir.y {
+		t.Errorf("left corner rays %v and %v aren't mirrored", bl.dir, tl.dir)
+	}
+}
+
+// asymmetricTexture returns a w x h texture in which every pixel differs,
+// so any flip or rotation shows.
+func asymmetricTexture(w, h int) *Texture {
+	t := NewTexture(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			t.SetRgba(x, y, byte(x*16), byte(y*16), byte(x+w*y), 255)
+		}
+	}
+	return t
+}
+
+func TestImageFormatsRoundTripTopLeft(t *testing.T) {
+	src := asymmetricTexture(5, 3)
+	var tga, png bytes.Buffer
+	if err := src.WriteTGA(&tga); err != nil {
+		t.Fatal(err)
+	}
+	if tga.Bytes()[17]&0x20 == 0 {
+		t.Errorf("TGA image descriptor %#x doesn't declare a top-left origin", tga.Bytes()[17])
+	}
+	par := filepath.Join(t.TempDir(), "par.tga")
+	f, err := os.Create(par)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.WriteTGAParallel(f, 2); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := src.WritePNG(&png); err != nil {
+		t.Fatal(err)
+	}
+	fromTGA, err := ReadTGA(&tga)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPar, err := loadImage(par)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPNG, err := ReadPNG(&png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string]*Texture{"TGA": fromTGA, "parallel TGA": fromPar, "PNG": fromPNG} {
+		if got.w != src.w || got.h != src.h || !bytes.Equal(got.buf, src.buf) {
+			t.Errorf("%s doesn't read back as written", name)
+		}
+	}
+}
+
+func TestRenderedTopRowIsTopOfScene(t *testing.T) {
+	// A white sky over a black ground, with a small sphere out of the way.
+	scene := mustScene(Vec3{0, -1, 0}, &Sphere{Vec3{0, 0, 100}, 0.1}, GradientBackground(Vec3{1, 1, 1}, Vec3{0, 0, 0}))
+	ren := NewRenderer(scene, NewTexture(20, 20), NewCamera(Vec3{}, 20, 20), 1)
+	img := mustRender(t, ren)
+	var buf bytes.Buffer
+	if err := img.WriteTGA(&buf); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadTGA(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, _, _, _ := back.GetRgba(0, 0)
+	bottom, _, _, _ := back.GetRgba(0, 19)
+	if top <= bottom {
+		t.Errorf("pixel 0, 0 is %d, not brighter than %d at the bottom, so it isn't the sky", top, bottom)
+	}
+}
+
+func TestOversizedTGARejected(t *testing.T) {
+	if code, out := runMain(t, t.TempDir(), "-width", "70000", "-height", "2"); code != 2 {
+		t.Errorf("70000 pixel wide TGA output exited with %d, want 2: %s", code, out)
+	}
+	if err := writeTGAHeader(io.Discard, 70000, 2); err == nil {
+		t.Errorf("wrote a TGA header for 70000 pixels")
+	}
+}
+
+func TestCheckpointsEveryNTiles(t *testing.T) {
+	for _, n := range []int{4, 5} {
+		// 4x4 tiles of 16x16 pixels.
+		ren := pyramidRenderer(64, 64, 3, 1)
+		ren.OutputEveryN = n
+		var mu sync.Mutex
+		var got []int
+		ren.CheckpointSaver = func(t *Texture, completedTiles int) {
+			mu.Lock()
+			got = append(got, completedTiles)
+			mu.Unlock()
+		}
+		mustRender(t, ren)
+		if len(got) != 16/n {
+			t.Errorf("N = %d: %d checkpoints, want %d", n, len(got), 16/n)
+		}
+		for _, c := range got {
+			if c%n != 0 {
+				t.Errorf("N = %d: checkpoint after %d tiles", n, c)
+			}
+		}
+	}
+}
+
+func TestOverscanCenterMatchesNominalRender(t *testing.T) {
+	const n = 5
+	want := mustRender(t, pyramidRenderer(40, 30, 3, 2))
+	nominal := NewCamera(Vec3{0, 0, -4.0}, 40, 30)
+	scene := pyramidRenderer(40, 30, 3, 2).scene
+	ren := NewRenderer(scene, NewTexture(40+2*n, 30+2*n), nominal.Overscan(n), 2)
+	got := mustRender(t, ren).Crop(Rect{n, n, n + 40, n + 30})
+	if !bytes.Equal(got.buf, want.buf) {
+		t.Errorf("center of the overscanned render differs from the nominal one")
+	}
+}
+
+func TestNegativeOverscanRejected(t *testing.T) {
+	for _, n := range []string{"-1", "-40"} {
+		if code, out := runMain(t, t.TempDir(), "-width", "64", "-height", "48", "-overscan", n); code != 2 {
+			t.Errorf("-overscan %s exited with %d, want 2: %s", n, code, out)
+		}
+	}
+}
+
+func TestUnnormalizedLightRendersSame(t *testing.T) {
+	render := func(light Vec3) *Texture {
+		scene := mustScene(light, createSpherePyramid(3, Vec3{0.0, -1.0, 0.0}, 1.0), SolidBackground(backgroundColor))
+		return mustRender(t, NewRenderer(scene, NewTexture(40, 30), NewCamera(Vec3{0, 0, -4.0}, 40, 30), 2))
+	}
+	light := Vec3{-1.0, -3.0, 2.0}
+	want := render(normalize(light))
+	for _, l := range []Vec3{light, vec3mulf(light, 0.01), vec3mulf(light, 1000)} {
+		if got := render(l); !bytes.Equal(got.buf, want.buf) {
+			t.Errorf("light %v renders differently from its normalized direction", l)
+		}
+	}
+}
+
+func TestNearestHitFromCenterIsFarSide(t *testing.T) {
+	s := &Sphere{Vec3{1, 2, 3}, 2}
+	r := Ray{s.center, normalize(Vec3{1, -1, 2})}
+	h, ok := s.NearestHit(&r)
+	if !ok {
+		t.Fatal("ray from the center didn't hit the sphere")
+	}
+	if !approx(h.distance, s.radius, 1e-5) || !h.inside {
+		t.Errorf("hit %+v, want the far surface %g away from inside", h, s.radius)
+	}
+	// The normal faces back towards the center.
+	if !approx(vec3dot(h.pos, r.dir), -1, 1e-5) {
+		t.Errorf("normal %v doesn't face inwards along %v", h.pos, r.dir)
+	}
+}
+
+func TestF2bBoundaries(t *testing.T) {
+	for _, c := range []struct {
+		f    float32
+		want byte
+	}{
+		{0, 0}, {1, 255}, {0.5, 128}, {-0.001, 0}, {1.001, 255},
+		{float32(math.NaN()), 0}, {float32(math.Inf(1)), 255}, {float32(math.Inf(-1)), 0},
+	} {
+		if got := f2b(c.f); got != c.want {
+			t.Errorf("f2b(%g) = %d, want %d", c.f, got, c.want)
+		}
+	}
+}
+
+func TestF2bRoundsToNearestStep(t *testing.T) {
+	// Every byte value maps back to itself.
+	for i := 0; i <= 255; i++ {
+		if got := f2b(float32(i) / 255); got != byte(i) {
+			t.Errorf("f2b(%d/255) = %d", i, got)
+		}
+	}
+	// A reference table of conventional rounding, in steps of 0.001.
+	for i := 0; i <= 1000; i++ {
+		f := float32(i) / 1000
+		want := byte(math.Round(float64(f) * 255))
+		if got := f2b(f); got != want {
+			t.Errorf("f2b(%g) = %d, want round(%g*255) = %d", f, got, f, want)
+		}
+	}
+}
+
+func TestReplayFlagsIncludeSceneOptions(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-width", "48", "-height", "32", "-aa", "jitter", "-seed", "3", "-gamma", "2.2", "-lod", "4",
+		"-prune", "0.5", "-time", "1.5", "-light-orbit", "4", "-scale-bias", "-verbose", "-o", "first.tga"}
+	code, out := runMain(t, dir, args...)
+	if code != 0 {
+		t.Fatalf("render failed: %s", out)
+	}
+	_, line, ok := strings.Cut(out, "replay with: ")
+	if !ok {
+		t.Fatalf("no replay flags logged: %s", out)
+	}
+	line, _, _ = strings.Cut(line, "\n")
+	for _, f := range []string{"-gamma 2.2", "-lod 4", "-prune 0.5", "-time 1.5", "-light-orbit 4", "-scale-bias=true"} {
+		if !strings.Contains(line, f) {
+			t.Errorf("replay flags %q lack %s", line, f)
+		}
+	}
+	replay := append(strings.Fields(line), "-o", "second.tga")
+	if code, out := runMain(t, dir, replay...); code != 0 {
+		t.Fatalf("replay %v failed: %s", replay, out)
+	}
+	a, err := os.ReadFile(filepath.Join(dir, "first.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "second.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("replaying with %v gave a different image", replay)
+	}
+}
+
+func TestSeedExcludesSeedFromOutput(t *testing.T) {
+	if code, out := runMain(t, t.TempDir(), "-width", "16", "-height", "16", "-seed", "0", "-seed-from-output"); code != 2 {
+		t.Errorf("-seed 0 with -seed-from-output exited with %d, want 2: %s", code, out)
+	}
+}
+
+func TestColorSumMeanOfManySamples(t *testing.T) {
+	const n = 10000
+	for _, v := range []float32{0.1, 0.7, 1234.567} {
+		// Summing these in float32 drifts by several ulps.
+		var s colorSum
+		for i := 0; i < n; i++ {
+			s.add(Vec3{v, v, v})
+		}
+		ulp := math.Nextafter32(v, infinity) - v
+		if m := s.mean(n); !approx(m.x, v, ulp) || m != (Vec3{m.x, m.x, m.x}) {
+			t.Errorf("mean of %d samples of %g is %v, more than one ulp off", n, v, m)
+		}
+	}
+}
+
+func TestManySamplesOfOneAverageToOne(t *testing.T) {
+	var s colorSum
+	for i := 0; i < 10000; i++ {
+		s.add(Vec3{1, 1, 1})
+	}
+	if m := s.mean(10000); m != (Vec3{1, 1, 1}) {
+		t.Errorf("mean of 10000 samples of 1 is %v", m)
+	}
+	// The same for a whole pixel, with 100*100 samples of a white background.
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, &Sphere{Vec3{0, 0, -10}, 1}, SolidBackground(Vec3{1, 1, 1}))
+	ren := NewRenderer(scene, NewTexture(2, 2), NewCamera(Vec3{0, 0, -4}, 2, 2), 100)
+	for i, c := range renderHDR(t, ren).pix {
+		if c != (Vec3{1, 1, 1}) {
+			t.Errorf("pixel %d averaged 10000 samples of 1 to %v", i, c)
+		}
+	}
+}
+
+// writeTGAFile writes t to a new file in dir with WriteTGA, or with
+// WriteTGAParallel if workers isn't 0, and returns its path.
+func writeTGAFile(tb testing.TB, dir string, t *Texture, workers int) string {
+	tb.Helper()
+	f, err := os.CreateTemp(dir, "*.tga")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	if workers == 0 {
+		err = t.WriteTGA(f)
+	} else {
+		err = t.WriteTGAParallel(f, workers)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestWriteTGAParallelMatchesWriteTGA(t *testing.T) {
+	dir := t.TempDir()
+	src := asymmetricTexture(37, 23)
+	want, err := os.ReadFile(writeTGAFile(t, dir, src, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Including more workers than rows.
+	for _, workers := range []int{1, 4, 23, 50} {
+		got, err := os.ReadFile(writeTGAFile(t, dir, src, workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d workers wrote a different file", workers)
+		}
+	}
+}
+
+func benchmarkWriteTGA(b *testing.B, workers int) {
+	t := NewTexture(4096, 4096)
+	dir := b.TempDir()
+	b.SetBytes(int64(tgaHeaderSize + 3*t.w*t.h))
+	for i := 0; i < b.N; i++ {
+		os.Remove(writeTGAFile(b, dir, t, workers))
+	}
+}
+
+func BenchmarkWriteTGA(b *testing.B) {
+	benchmarkWriteTGA(b, 0)
+}
+
+func BenchmarkWriteTGAParallel(b *testing.B) {
+	benchmarkWriteTGA(b, runtime.GOMAXPROCS(0))
+}
+
+// BenchmarkShadowRays measures the throughput of the shadow rays of a
+// pyramid standing on a ground, which is a sphere large enough to look flat.
+// All rays are set up first, so only their intersection is timed.
+func BenchmarkShadowRays(b *testing.B) {
+	const w, h = 256, 192
+	ground := &Sphere{Vec3{0, -1001.5, 0}, 1000}
+	g := NewGroup(Sphere{Vec3{}, 2000}, []Geometry{ground, createSpherePyramid(5, Vec3{0.0, -1.0, 0.0}, 1.0)})
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, g, SolidBackground(backgroundColor))
+	cam := NewCamera(Vec3{0, 0, -4.0}, w, h)
+	var rays []Ray
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r := cam.RayFor(float32(x)+0.5, float32(y)+0.5)
+			hit := hitinfinity
+			g.Intersect(&hit, &r)
+			if hit.distance < infinity && vec3dot(hit.pos, scene.light) < 0 {
+				sr := Ray{r.At(hit.distance), vec3mulf(scene.light, -1.0)}
+				rays = append(rays, sr.Offset(hit.pos, delta))
+			}
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range rays {
+			hit := hitinfinity
+			g.Intersect(&hit, &rays[j])
+		}
+	}
+	b.ReportMetric(float64(b.N*len(rays))/b.Elapsed().Seconds(), "rays/s")
+}
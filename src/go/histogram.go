@@ -0,0 +1,44 @@
+package main
+
+// Histogram counts how many pixels of t have each value, per color channel.
+// The values are those stored, after gamma encoding and rounding to bytes,
+// so piles at 0 or 255 show clipped shadows or highlights.
+func (t *Texture) Histogram() [256][3]uint32 {
+	var h [256][3]uint32
+	for o := 0; o < len(t.buf); o += 4 {
+		for c := 0; c < 3; c++ {
+			h[t.buf[o+c]][c]++
+		}
+	}
+	return h
+}
+
+// HistogramEq returns t with each color channel spread out so that its
+// values are about evenly distributed over [0, 255]. Channels with a single
+// value are left as they are. Alpha is kept.
+func (t *Texture) HistogramEq() *Texture {
+	h := t.Histogram()
+	var lut [256][3]byte
+	for c := 0; c < 3; c++ {
+		// The cumulative count of the lowest value present maps to 0.
+		var cdf, low uint32
+		for v := 0; v < 256; v++ {
+			if low == 0 {
+				low = h[v][c]
+			}
+			cdf += h[v][c]
+			lut[v][c] = byte(v)
+			if n := uint32(t.w*t.h) - low; n > 0 && cdf >= low {
+				lut[v][c] = byte((uint64(cdf-low)*255 + uint64(n)/2) / uint64(n))
+			}
+		}
+	}
+	out := NewTexture(t.w, t.h)
+	for o := 0; o < len(t.buf); o += 4 {
+		for c := 0; c < 3; c++ {
+			out.buf[o+c] = lut[t.buf[o+c]][c]
+		}
+		out.buf[o+3] = t.buf[o+3]
+	}
+	return out
+}
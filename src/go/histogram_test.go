@@ -0,0 +1,60 @@
+package main
+
+import testing "testing"
+
+// gradient returns a texture 3 rows high whose channels rise by one value
+// per column, from low, with alpha 200.
+func gradient(w int, low byte) *Texture {
+	t := NewTexture(w, 3)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < w; x++ {
+			v := low + byte(x)
+			t.SetRgba(x, y, v, v, v, 200)
+		}
+	}
+	return t
+}
+
+func TestHistogramOfGradientIsFlat(t *testing.T) {
+	h := gradient(256, 0).Histogram()
+	for v := range h {
+		for c := 0; c < 3; c++ {
+			if h[v][c] != 3 {
+				t.Fatalf("value %d of channel %d counted %d times, want 3", v, c, h[v][c])
+			}
+		}
+	}
+}
+
+func TestHistogramEqSpreadsNarrowRange(t *testing.T) {
+	src := gradient(32, 100)
+	eq := src.HistogramEq()
+	h := eq.Histogram()
+	// The 32 values become evenly spaced over the full range, from 0 for
+	// the lowest to 255 for the highest.
+	for x := 0; x < 32; x++ {
+		want := byte((x*255 + 31/2) / 31)
+		r, g, b, a := eq.GetRgba(x, 1)
+		if r != want || g != want || b != want || a != 200 {
+			t.Errorf("value %d became %d, %d, %d, %d, want %d and alpha 200", 100+x, r, g, b, a, want)
+		}
+		if h[want][0] != 3 {
+			t.Errorf("value %d counted %d times, want 3", want, h[want][0])
+		}
+	}
+}
+
+func TestHistogramEqKeepsSingleValue(t *testing.T) {
+	src := NewTexture(4, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetRgba(x, y, 70, byte(x), 70, 255)
+		}
+	}
+	eq := src.HistogramEq()
+	for x := 0; x < 4; x++ {
+		if r, _, b, _ := eq.GetRgba(x, 2); r != 70 || b != 70 {
+			t.Errorf("single valued channels became %d and %d", r, b)
+		}
+	}
+}
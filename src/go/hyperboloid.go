@@ -0,0 +1,124 @@
+package main
+
+import errors "errors"
+import fmt "fmt"
+import math "math"
+
+// Hyperboloid is the open surface swept by revolving the line segment from p1
+// to p2 around axis through center, for angles within [phiMin, phiMax]
+// radians. Depending on the segment it is a one-sheeted hyperboloid, a cone
+// or a cylinder.
+//
+// p1 and p2 are in a right-handed local frame with center at the origin,
+// axis as +z and the part of ref perpendicular to axis as +x. Angles start
+// at +x and grow towards +y, counterclockwise when looking against axis.
+type Hyperboloid struct {
+	center, axis       Vec3
+	u, v               Vec3 // the local +x and +y
+	p1, p2             Vec3
+	phiMin, phiMax     float32
+	zmin, zmax         float32
+	alpha, beta, gamma float32 // x^2 + y^2 = alpha*z^2 + beta*z + gamma
+}
+
+func NewHyperboloid(center, axis, ref, p1, p2 Vec3, phiMin, phiMax float32) (*Hyperboloid, error) {
+	a, ok := NormalizeSafe(axis)
+	if !ok {
+		return nil, errors.New("hyperboloid axis must not be zero")
+	}
+	u, ok := NormalizeSafe(vec3sub(ref, vec3mulf(a, vec3dot(ref, a))))
+	switch {
+	case !ok:
+		return nil, errors.New("hyperboloid reference direction must not be zero or parallel to its axis")
+	case p1.z == p2.z:
+		return nil, errors.New("hyperboloid end points must be at different heights")
+	case phiMin < 0 || phiMax > 2*math.Pi || phiMax <= phiMin:
+		return nil, fmt.Errorf("hyperboloid angle range [%g, %g] is not within [0, 2*Pi]", phiMin, phiMax)
+	}
+	h := &Hyperboloid{center: center, axis: a, u: u, v: vec3cross(a, u), p1: p1, p2: p2, phiMin: phiMin, phiMax: phiMax}
+	h.zmin, h.zmax = min(p1.z, p2.z), max(p1.z, p2.z)
+
+	// A point of the segment at s in [0, 1] has the squared distance
+	// R(s) = ra*s^2 + rb*s + rc from the axis, and is at height z for
+	// s = k*z + m. Substituting s gives the implicit form.
+	d := vec3sub(p2, p1)
+	ra := d.x*d.x + d.y*d.y
+	rb := 2 * (p1.x*d.x + p1.y*d.y)
+	rc := p1.x*p1.x + p1.y*p1.y
+	k := 1 / d.z
+	m := -p1.z / d.z
+	h.alpha = ra * k * k
+	h.beta = 2*ra*k*m + rb*k
+	h.gamma = ra*m*m + rb*m + rc
+	return h, nil
+}
+
+// toLocal returns w in the hyperboloid's local frame.
+func (hy *Hyperboloid) toLocal(w Vec3) Vec3 {
+	return Vec3{vec3dot(w, hy.u), vec3dot(w, hy.v), vec3dot(w, hy.axis)}
+}
+
+func (hy *Hyperboloid) Intersect(h *Hit, r *Ray) {
+	o := hy.toLocal(vec3sub(r.orig, hy.center))
+	d := hy.toLocal(r.dir)
+
+	a := d.x*d.x + d.y*d.y - hy.alpha*d.z*d.z
+	b := 2*(o.x*d.x+o.y*d.y) - (2*hy.alpha*o.z+hy.beta)*d.z
+	c := o.x*o.x + o.y*o.y - (hy.alpha*o.z*o.z + hy.beta*o.z + hy.gamma)
+	var ts [2]float32
+	n := 0
+	if a > -1e-6 && a < 1e-6 {
+		// The ray is parallel to an asymptote and crosses the surface once.
+		if b != 0 {
+			ts[0], n = -c/b, 1
+		}
+	} else if disc := b*b - 4*a*c; disc >= 0 {
+		sd := sqrtf(disc)
+		q := -0.5 * (b + sd)
+		if b < 0 {
+			q = -0.5 * (b - sd)
+		}
+		ts[0], n = q/a, 1
+		if q != 0 {
+			ts[1], n = c/q, 2
+		}
+		if n == 2 && ts[0] > ts[1] {
+			ts[0], ts[1] = ts[1], ts[0]
+		}
+	}
+	for _, t := range ts[:n] {
+		if t <= 0 || t >= h.distance {
+			continue
+		}
+		p := vec3add(o, vec3mulf(d, t))
+		if p.z < hy.zmin || p.z > hy.zmax {
+			continue
+		}
+		phi := float32(math.Atan2(float64(p.y), float64(p.x)))
+		if phi < 0 {
+			phi += 2 * math.Pi
+		}
+		if phi < hy.phiMin || phi > hy.phiMax {
+			continue
+		}
+		// The gradient of the implicit form.
+		ln, ok := NormalizeSafe(Vec3{p.x, p.y, -(hy.alpha*p.z + 0.5*hy.beta)})
+		if !ok {
+			// On the axis, where the surface degenerates to a cone's tip.
+			ln = Vec3{0, 0, 1}
+		}
+		nw := vec3add(vec3mulf(hy.u, ln.x), vec3add(vec3mulf(hy.v, ln.y), vec3mulf(hy.axis, ln.z)))
+		if vec3dot(nw, r.dir) > 0 {
+			nw = vec3mulf(nw, -1)
+		}
+		h.distance = t
+		h.pos = nw
+		h.inside = false
+		h.geom = hy
+		return
+	}
+}
+
+func (hy *Hyperboloid) Print() {
+	fmt.Println("Hyperboloid:", *hy)
+}
@@ -0,0 +1,89 @@
+package main
+
+import math "math"
+import testing "testing"
+
+// zHyperboloid returns the surface swept from p1 to p2 around the z axis
+// through the origin, with angles starting at ref.
+func zHyperboloid(t *testing.T, ref, p1, p2 Vec3, phiMin, phiMax float32) *Hyperboloid {
+	t.Helper()
+	h, err := NewHyperboloid(Vec3{}, Vec3{0, 0, 1}, ref, p1, p2, phiMin, phiMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHyperboloidIntersect(t *testing.T) {
+	s := float32(math.Sqrt2 / 2)
+	x := Vec3{1, 0, 0}
+	cylinder := zHyperboloid(t, x, Vec3{1, 0, 0}, Vec3{1, 0, 2}, 0, 2*math.Pi)
+	// Twisting the segment by 90 degrees gives x^2 + y^2 = 0.5*z^2 + 0.5,
+	// whose middle narrows to a radius of sqrt(0.5).
+	waist := zHyperboloid(t, x, Vec3{1, 0, -1}, Vec3{0, 1, 1}, 0, 2*math.Pi)
+	cone := zHyperboloid(t, x, Vec3{1, 0, 0}, Vec3{0, 0, 1}, 0, 2*math.Pi)
+	for _, c := range []struct {
+		name   string
+		h      *Hyperboloid
+		r      Ray
+		dist   float32
+		normal Vec3
+	}{
+		{"cylinder", cylinder, Ray{Vec3{-5, 0, 1}, Vec3{1, 0, 0}}, 4, Vec3{-1, 0, 0}},
+		{"cylinder from inside", cylinder, Ray{Vec3{0, 0, 1}, Vec3{0, 1, 0}}, 1, Vec3{0, -1, 0}},
+		{"waist", waist, Ray{Vec3{-5, 0, 0}, Vec3{1, 0, 0}}, 5 - s, Vec3{-1, 0, 0}},
+		{"waist end", waist, Ray{Vec3{0, -5, -1}, Vec3{0, 1, 0}}, 4, Vec3{0, -2 / sqrtf(5), 1 / sqrtf(5)}},
+		{"cone", cone, Ray{Vec3{-5, 0, 0}, Vec3{1, 0, 0}}, 4, Vec3{-s, 0, s}},
+	} {
+		h := hitinfinity
+		c.h.Intersect(&h, &c.r)
+		if h.geom != c.h || !approx(h.distance, c.dist, 1e-4) {
+			t.Errorf("%s: hit %v at %g, want %g", c.name, h.geom, h.distance, c.dist)
+			continue
+		}
+		if n := h.pos; !approx(n.x, c.normal.x, 1e-4) || !approx(n.y, c.normal.y, 1e-4) || !approx(n.z, c.normal.z, 1e-4) {
+			t.Errorf("%s: normal is %v, want %v", c.name, n, c.normal)
+		}
+	}
+	// Beyond the ends of the segment.
+	for _, r := range []Ray{{Vec3{-5, 0, 2.5}, Vec3{1, 0, 0}}, {Vec3{-5, 0, -0.5}, Vec3{1, 0, 0}}} {
+		h := hitinfinity
+		cylinder.Intersect(&h, &r)
+		if h.distance != infinity {
+			t.Errorf("ray %v hit the cylinder at %g, beyond its ends", r, h.distance)
+		}
+	}
+}
+
+func TestHyperboloidClipsAngles(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		ref  Vec3
+		r    Ray
+		dist float32
+	}{
+		// Angles from +x towards +y keep the half at y >= 0, so the near
+		// crossing at y = -1 is clipped.
+		{"from -y", Vec3{1, 0, 0}, Ray{Vec3{0, -5, 1}, Vec3{0, 1, 0}}, 6},
+		{"from +y", Vec3{1, 0, 0}, Ray{Vec3{0, 5, 1}, Vec3{0, -1, 0}}, 4},
+		// Starting at +y they keep the half at x <= 0. Only the direction of
+		// ref perpendicular to the axis counts.
+		{"from +x", Vec3{0, 2, 7}, Ray{Vec3{5, 0, 1}, Vec3{-1, 0, 0}}, 6},
+		{"from -x", Vec3{0, 2, 7}, Ray{Vec3{-5, 0, 1}, Vec3{1, 0, 0}}, 4},
+	} {
+		half := zHyperboloid(t, c.ref, Vec3{1, 0, 0}, Vec3{1, 0, 2}, 0, math.Pi)
+		h := hitinfinity
+		half.Intersect(&h, &c.r)
+		if !approx(h.distance, c.dist, 1e-4) {
+			t.Errorf("%s: hit at %g, want %g", c.name, h.distance, c.dist)
+		}
+	}
+}
+
+func TestNewHyperboloidRejectsReferenceAlongAxis(t *testing.T) {
+	for _, ref := range []Vec3{{0, 0, 3}, {0, 0, -1}, {}} {
+		if _, err := NewHyperboloid(Vec3{}, Vec3{0, 0, 1}, ref, Vec3{1, 0, 0}, Vec3{1, 0, 2}, 0, math.Pi); err == nil {
+			t.Errorf("reference direction %v was accepted", ref)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import fmt "fmt"
+import math "math"
+
+// AtTime returns s with its light where LightPath puts it at time t, or s
+// itself if the light is static. Both scenes share their geometry. It fails
+// if LightPath gives no direction at t.
+func (s *Scene) AtTime(t float32) (*Scene, error) {
+	if s.LightPath == nil {
+		return s, nil
+	}
+	l, ok := NormalizeSafe(s.LightPath(t))
+	if !ok {
+		return nil, fmt.Errorf("light path has no direction at time %g", t)
+	}
+	at := *s
+	at.light = l
+	return &at, nil
+}
+
+// OrbitLight returns a light path which turns dir around the vertical axis,
+// once every period seconds, as for turntables. At time 0 the light shines
+// along dir.
+func OrbitLight(dir Vec3, period float32) func(t float32) Vec3 {
+	return func(t float32) Vec3 {
+		sin, cos := math.Sincos(2 * math.Pi * float64(t/period))
+		s, c := float32(sin), float32(cos)
+		return Vec3{c*dir.x + s*dir.z, dir.y, c*dir.z - s*dir.x}
+	}
+}
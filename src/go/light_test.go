@@ -0,0 +1,76 @@
+package main
+
+import math "math"
+import testing "testing"
+
+// litCentroid returns the mean position of the pixels of a render of scene
+// which are brighter than the sphere's ambient color.
+func litCentroid(t *testing.T, scene *Scene) (float32, float32) {
+	const w, h = 60, 40
+	img := mustRender(t, NewRenderer(scene, NewTexture(w, h), NewCamera(Vec3{0, 0, -4}, w, h), 1))
+	var sx, sy, n float32
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if img.GetV(x, y).y > ambientSphereColor.y+0.05 {
+				sx, sy, n = sx+float32(x), sy+float32(y), n+1
+			}
+		}
+	}
+	if n == 0 {
+		t.Fatal("no pixel is lit")
+	}
+	return sx/n - w/2, sy/n - h/2
+}
+
+// mustAtTime returns scene.AtTime(time), failing t if that fails.
+func mustAtTime(t *testing.T, scene *Scene, time float32) *Scene {
+	t.Helper()
+	at, err := scene.AtTime(time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestOrbitLightMovesHighlight(t *testing.T) {
+	// From the upper left, across the view, so both sides are seen lit.
+	scene := mustScene(Vec3{1, -1, 0}, &Sphere{Vec3{0, 0, 0}, 1}, SolidBackground(backgroundColor))
+	scene.LightPath = OrbitLight(Vec3{1, -1, 0}, 4)
+	x0, y0 := litCentroid(t, mustAtTime(t, scene, 0))
+	// Half a turn later the light comes from the opposite side, at the same
+	// height.
+	x1, y1 := litCentroid(t, mustAtTime(t, scene, 2))
+	if x0 >= -1 || x1 <= 1 || !approx(x0, -x1, 0.5) {
+		t.Errorf("lit region moved horizontally from %g to %g, want it to swap from left to right", x0, x1)
+	}
+	if !approx(y0, y1, 1) {
+		t.Errorf("lit region moved vertically from %g to %g", y0, y1)
+	}
+	if l := mustAtTime(t, scene, 4).light; !approx(l.x, scene.light.x, 1e-5) || !approx(l.z, scene.light.z, 1e-5) {
+		t.Errorf("light is %v after a full turn, want %v", l, scene.light)
+	}
+}
+
+func TestAtTimeWithoutLightPath(t *testing.T) {
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, &Sphere{Vec3{0, 0, 0}, 1}, SolidBackground(backgroundColor))
+	if at := mustAtTime(t, scene, 3); at != scene {
+		t.Errorf("AtTime of a static light returned a different scene")
+	}
+}
+
+func TestLightWithoutDirectionRejected(t *testing.T) {
+	nan := float32(math.NaN())
+	for _, light := range []Vec3{{}, {nan, -1, 0}, {0, float32(math.Inf(-1)), 0}} {
+		if _, err := createScene(light, &Sphere{Vec3{}, 1}, SolidBackground(backgroundColor)); err == nil {
+			t.Errorf("scene lit along %v was created", light)
+		}
+	}
+	scene := mustScene(Vec3{-1.0, -3.0, 2.0}, &Sphere{Vec3{}, 1}, SolidBackground(backgroundColor))
+	scene.LightPath = func(t float32) Vec3 { return Vec3{0, 0, 1 - t} }
+	if _, err := scene.AtTime(1); err == nil {
+		t.Error("AtTime accepted a light path without direction at time 1")
+	}
+	if at, err := scene.AtTime(3); err != nil || at.light != (Vec3{0, 0, -1}) {
+		t.Errorf("AtTime(3) = %v, %v, want a light along -z", at, err)
+	}
+}
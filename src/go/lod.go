@@ -0,0 +1,79 @@
+package main
+
+import fmt "fmt"
+import sort "sort"
+
+// LODLevel is a representation of an LODGeometry to use while its bound
+// appears at least threshold pixels in radius.
+type LODLevel struct {
+	threshold float32
+	geo       Geometry
+}
+
+// LODGeometry switches between representations of the same object by how
+// large it appears to the camera. The size is measured from the eye rather
+// than the ray origin, so shadow rays see the same level as the primary rays
+// whose hits they leave from, and can't shadow one level with another.
+type LODGeometry struct {
+	cam    *Camera
+	bound  Sphere
+	levels []LODLevel // by decreasing threshold
+}
+
+// NewLOD returns a geometry which uses the level with the largest threshold
+// not above the projected radius of bound in pixels of cam, or the one with
+// the smallest threshold if there is none.
+func NewLOD(cam *Camera, bound Sphere, levels ...LODLevel) *LODGeometry {
+	l := append([]LODLevel(nil), levels...)
+	sort.Slice(l, func(i, j int) bool { return l[i].threshold > l[j].threshold })
+	return &LODGeometry{cam, bound, l}
+}
+
+// level returns the representation to use for all rays.
+func (g *LODGeometry) level() Geometry {
+	// Compare squares, as size = radius*focal/dist, to avoid a sqrt per ray.
+	d := vec3sub(g.bound.center, g.cam.eye)
+	dist2 := vec3dot(d, d)
+	rf := g.bound.radius * g.cam.focal
+	for _, l := range g.levels {
+		if rf*rf >= l.threshold*l.threshold*dist2 {
+			return l.geo
+		}
+	}
+	return g.levels[len(g.levels)-1].geo
+}
+
+func (g *LODGeometry) Intersect(h *Hit, r *Ray) {
+	g.level().Intersect(h, r)
+}
+
+func (g *LODGeometry) Print() {
+	fmt.Printf("LODGeometry: %v\n", g.bound)
+	for _, l := range g.levels {
+		fmt.Printf("  %g: ", l.threshold)
+		l.geo.Print()
+	}
+}
+
+// createSpherePyramidLOD is createSpherePyramid, except that each sub-pyramid
+// is reduced to its top sphere while its bound appears smaller than minSize
+// pixels in radius.
+func createSpherePyramidLOD(cam *Camera, minSize float32, level int, c Vec3, r float32) Geometry {
+	s := &Sphere{c, r}
+	if level == 1 {
+		return s
+	}
+	children := make([]Geometry, 5)
+	children[0] = s
+	i := 1
+	rn := 3.0 * r / sqrtf(12.0)
+	for dz := -1; dz <= 1; dz += 2 {
+		for dx := -1; dx <= 1; dx += 2 {
+			newc := vec3add(c, vec3mulf(Vec3{float32(dx), 1.0, float32(dz)}, rn))
+			children[i] = createSpherePyramidLOD(cam, minSize, level-1, newc, r*0.5)
+			i++
+		}
+	}
+	bound := Sphere{c, 3 * r}
+	return NewLOD(cam, bound, LODLevel{minSize, NewGroup(bound, children)}, LODLevel{0, s})
+}
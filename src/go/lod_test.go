@@ -0,0 +1,36 @@
+package main
+
+import testing "testing"
+
+// lodHit returns the primitive hit by a ray from orig towards the origin.
+func lodHit(g Geometry, orig Vec3) Geometry {
+	h := hitinfinity
+	g.Intersect(&h, &Ray{orig, vec3sub(Vec3{}, orig).normalized()})
+	return h.geom
+}
+
+func TestLODSwitchesAtThreshold(t *testing.T) {
+	fine, coarse := &Sphere{Vec3{}, 1}, &Sphere{Vec3{}, 0.9}
+	// With a focal length of 100 pixels the bound appears 10 pixels in
+	// radius at distance 10.
+	for _, c := range []struct {
+		dist float32
+		want Geometry
+	}{{9.9, fine}, {10, fine}, {10.1, coarse}} {
+		cam := NewCamera(Vec3{0, 0, -c.dist}, 100, 100)
+		lod := NewLOD(cam, Sphere{Vec3{}, 1}, LODLevel{0, coarse}, LODLevel{10, fine})
+		if g := lodHit(lod, cam.eye); g != c.want {
+			t.Errorf("at distance %g hit %v, want %v", c.dist, g, c.want)
+		}
+	}
+}
+
+func TestLODLevelIndependentOfRayOrigin(t *testing.T) {
+	fine, coarse := &Sphere{Vec3{}, 1}, &Sphere{Vec3{}, 0.9}
+	cam := NewCamera(Vec3{0, 0, -20}, 100, 100)
+	lod := NewLOD(cam, Sphere{Vec3{}, 1}, LODLevel{0, coarse}, LODLevel{10, fine})
+	// A shadow ray from near the object must see the level the camera does.
+	if g := lodHit(lod, Vec3{0, 2, 0}); g != coarse {
+		t.Errorf("ray from near the object hit %v, want the coarse level %v", g, coarse)
+	}
+}
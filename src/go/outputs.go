@@ -0,0 +1,141 @@
+package main
+
+import fmt "fmt"
+import image "image"
+import draw "image/draw"
+import png "image/png"
+import io "io"
+import os "os"
+import filepath "path/filepath"
+import strings "strings"
+
+// output is a buffer of the render to be written to path, in the format
+// given by its extension.
+type output struct {
+	buffer string // one of outputBuffers
+	path   string
+}
+
+var outputBuffers = []string{"beauty", "albedo", "normal", "depth"}
+
+// outputList is a flag which can be given repeatedly, as buffer:path or
+// just path for the beauty image.
+type outputList []output
+
+func (l *outputList) String() string {
+	var s []string
+	for _, o := range *l {
+		s = append(s, o.buffer+":"+o.path)
+	}
+	return strings.Join(s, ",")
+}
+
+// Set adds the output v. Only a known buffer name before the first colon is
+// taken as the buffer, so other paths may contain colons, like C:\out.tga.
+func (l *outputList) Set(v string) error {
+	o := output{"beauty", v}
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		for _, b := range outputBuffers {
+			if b == v[:i] {
+				o.buffer, o.path = v[:i], v[i+1:]
+			}
+		}
+	}
+	if o.path == "" {
+		return fmt.Errorf("empty output path")
+	}
+	*l = append(*l, o)
+	return nil
+}
+
+func (l outputList) wants(buffer string) bool {
+	for _, o := range l {
+		if o.buffer == buffer {
+			return true
+		}
+	}
+	return false
+}
+
+func isPNG(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".png")
+}
+
+// WritePNG writes t to w as PNG.
+func (t *Texture) WritePNG(w io.Writer) error {
+	img := &image.NRGBA{Pix: t.buf, Stride: 4 * t.w, Rect: image.Rect(0, 0, t.w, t.h)}
+	return png.Encode(w, img)
+}
+
+// ReadPNG reads a PNG image of any color type.
+func ReadPNG(r io.Reader) (*Texture, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	return &Texture{b.Dx(), b.Dy(), nrgba.Pix}, nil
+}
+
+// loadImage reads the PNG or TGA image at path, depending on its name.
+func loadImage(path string) (*Texture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var t *Texture
+	if isPNG(path) {
+		t, err = ReadPNG(f)
+	} else {
+		t, err = ReadTGA(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// DepthTexture returns the depth buffer as a gray image which is white at the
+// nearest hit and black at the farthest one, and where nothing was hit.
+func (ren *Renderer) DepthTexture() *Texture {
+	near, far := infinity, float32(0)
+	for _, d := range ren.depth {
+		if d != infinity {
+			near, far = min(near, d), max(far, d)
+		}
+	}
+	t := NewTexture(ren.xres, ren.yres)
+	for i, d := range ren.depth {
+		var v float32
+		if d != infinity {
+			v = 1
+			if far > near {
+				v = 1 - (d-near)/(far-near)
+			}
+		}
+		t.SetV(i%ren.xres, i/ren.xres, Vec3{v, v, v})
+	}
+	return t
+}
+
+// saveImage replaces the contents of od with t, as PNG or TGA depending on
+// its name, and closes it.
+func saveImage(od *os.File, t *Texture) error {
+	if !isPNG(od.Name()) {
+		return saveTGA(od, t)
+	}
+	err := od.Truncate(0)
+	if err == nil {
+		err = t.WritePNG(od)
+	}
+	if cerr := od.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %v", od.Name(), err)
+	}
+	return nil
+}
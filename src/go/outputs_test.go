@@ -0,0 +1,57 @@
+package main
+
+import bytes "bytes"
+import os "os"
+import filepath "path/filepath"
+import testing "testing"
+
+func TestOutputListSet(t *testing.T) {
+	for _, c := range []struct {
+		flag string
+		want output
+	}{
+		{"out.tga", output{"beauty", "out.tga"}},
+		{"normal:n.png", output{"normal", "n.png"}},
+		{`C:\out.tga`, output{"beauty", `C:\out.tga`}},
+		{"run:2.tga", output{"beauty", "run:2.tga"}},
+		{"depth:run:2.tga", output{"depth", "run:2.tga"}},
+	} {
+		var l outputList
+		if err := l.Set(c.flag); err != nil {
+			t.Errorf("Set(%q): %v", c.flag, err)
+		} else if l[0] != c.want {
+			t.Errorf("Set(%q) added %+v, want %+v", c.flag, l[0], c.want)
+		}
+	}
+	for _, v := range []string{"", "albedo:"} {
+		var l outputList
+		if err := l.Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded", v)
+		}
+	}
+}
+
+func TestRenderWritesTwoOutputs(t *testing.T) {
+	dir := t.TempDir()
+	if code, out := runMain(t, dir, "-width", "32", "-height", "24", "-o", "run:1.tga", "-o", "normal:n.png"); code != 0 {
+		t.Fatalf("render failed: %s", out)
+	}
+	beauty, err := os.ReadFile(filepath.Join(dir, "run:1.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	normal, err := loadImage(filepath.Join(dir, "n.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ReadTGA(bytes.NewReader(beauty))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.w != 32 || b.h != 24 || normal.w != 32 || normal.h != 24 {
+		t.Fatalf("outputs are %dx%d and %dx%d, want 32x24", b.w, b.h, normal.w, normal.h)
+	}
+	if bytes.Equal(b.buf, normal.buf) {
+		t.Errorf("beauty and normal outputs are the same image")
+	}
+}
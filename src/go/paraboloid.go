@@ -0,0 +1,102 @@
+package main
+
+import errors "errors"
+import fmt "fmt"
+
+// Paraboloid is an open, two-sided dish around axis, whose surface reflects
+// rays parallel to the axis into focus. In its local space, with axis as +x
+// and the vertex at the origin, it is y^2 + z^2 = 4*focal*x for x within
+// [zmin, zmax], the depth range along the axis.
+type Paraboloid struct {
+	focus, axis Vec3
+	focal       float32
+	zmin, zmax  float32
+	u, v        Vec3 // completing axis to the local frame
+}
+
+// NewParaboloid returns a paraboloid, where axis doesn't need to be
+// normalized and points from the vertex towards the focus.
+func NewParaboloid(focus, axis Vec3, focal, zmin, zmax float32) (*Paraboloid, error) {
+	a, ok := NormalizeSafe(axis)
+	switch {
+	case !ok:
+		return nil, errors.New("paraboloid axis must not be zero")
+	case focal <= 0:
+		return nil, errors.New("paraboloid focal length must be positive")
+	case zmin < 0 || zmax <= zmin:
+		return nil, fmt.Errorf("paraboloid depth range [%g, %g] is empty or behind the vertex", zmin, zmax)
+	}
+	u, v := orthonormalBasis(a)
+	return &Paraboloid{focus, a, focal, zmin, zmax, u, v}, nil
+}
+
+// orthonormalBasis returns two unit vectors which are perpendicular to each
+// other and to the unit vector a.
+func orthonormalBasis(a Vec3) (Vec3, Vec3) {
+	t := Vec3{1, 0, 0}
+	if a.x > 0.9 || a.x < -0.9 {
+		t = Vec3{0, 1, 0}
+	}
+	u := normalize(vec3cross(a, t))
+	return u, vec3cross(a, u)
+}
+
+// toLocal returns w in the paraboloid's local frame.
+func (p *Paraboloid) toLocal(w Vec3) Vec3 {
+	return Vec3{vec3dot(w, p.axis), vec3dot(w, p.u), vec3dot(w, p.v)}
+}
+
+func (p *Paraboloid) Intersect(h *Hit, r *Ray) {
+	vertex := vec3sub(p.focus, vec3mulf(p.axis, p.focal))
+	o := p.toLocal(vec3sub(r.orig, vertex))
+	d := p.toLocal(r.dir)
+
+	f4 := 4 * p.focal
+	a := d.y*d.y + d.z*d.z
+	b := 2*(o.y*d.y+o.z*d.z) - f4*d.x
+	c := o.y*o.y + o.z*o.z - f4*o.x
+	var ts [2]float32
+	n := 0
+	if a < 1e-12 {
+		// Rays parallel to the axis cross the surface once.
+		if b != 0 {
+			ts[0], n = -c/b, 1
+		}
+	} else if disc := b*b - 4*a*c; disc >= 0 {
+		sd := sqrtf(disc)
+		q := -0.5 * (b + sd)
+		if b < 0 {
+			q = -0.5 * (b - sd)
+		}
+		ts[0], n = q/a, 1
+		if q != 0 {
+			ts[1], n = c/q, 2
+		}
+		if n == 2 && ts[0] > ts[1] {
+			ts[0], ts[1] = ts[1], ts[0]
+		}
+	}
+	for _, t := range ts[:n] {
+		if t <= 0 || t >= h.distance {
+			continue
+		}
+		l := vec3add(o, vec3mulf(d, t))
+		if l.x < p.zmin || l.x > p.zmax {
+			continue
+		}
+		ln := normalize(Vec3{2 * p.focal, -l.y, -l.z})
+		nw := vec3add(vec3mulf(p.axis, ln.x), vec3add(vec3mulf(p.u, ln.y), vec3mulf(p.v, ln.z)))
+		if vec3dot(nw, r.dir) > 0 {
+			nw = vec3mulf(nw, -1)
+		}
+		h.distance = t
+		h.pos = nw
+		h.inside = false
+		h.geom = p
+		return
+	}
+}
+
+func (p *Paraboloid) Print() {
+	fmt.Println("Paraboloid:", *p)
+}
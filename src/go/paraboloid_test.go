@@ -0,0 +1,87 @@
+package main
+
+import math "math"
+import testing "testing"
+
+func TestParaboloidTangentAtVertex(t *testing.T) {
+	p, err := NewParaboloid(Vec3{1, 0, 0}, Vec3{1, 0, 0}, 1, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Starting at the vertex, across the axis, both roots are 0.
+	r := Ray{Vec3{}, Vec3{0, 1, 0}}
+	h := hitinfinity
+	p.Intersect(&h, &r)
+	if h.distance != infinity {
+		t.Errorf("ray leaving the vertex sideways hit at %g", h.distance)
+	}
+}
+
+func TestParaboloidIntersect(t *testing.T) {
+	// y^2 + z^2 = 4x around the x axis, with the vertex at the origin.
+	p, err := NewParaboloid(Vec3{1, 0, 0}, Vec3{1, 0, 0}, 1, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := float32(math.Sqrt2 / 2)
+	for _, c := range []struct {
+		name   string
+		r      Ray
+		dist   float32
+		normal Vec3
+	}{
+		{"outside", Ray{Vec3{1, 5, 0}, Vec3{0, -1, 0}}, 3, Vec3{-s, s, 0}},
+		// From the focus, the near root is behind the ray.
+		{"inside", Ray{Vec3{1, 0, 0}, Vec3{0, 1, 0}}, 2, Vec3{s, -s, 0}},
+		{"inside sideways", Ray{Vec3{1, 0, 0}, Vec3{0, 0, -1}}, 2, Vec3{s, 0, s}},
+		{"along the axis", Ray{Vec3{5, 0, 0}, Vec3{-1, 0, 0}}, 5, Vec3{1, 0, 0}},
+	} {
+		h := hitinfinity
+		p.Intersect(&h, &c.r)
+		if h.geom != p || !approx(h.distance, c.dist, 1e-5) {
+			t.Errorf("%s: hit %v at %g, want %g", c.name, h.geom, h.distance, c.dist)
+			continue
+		}
+		if n := h.pos; !approx(n.x, c.normal.x, 1e-5) || !approx(n.y, c.normal.y, 1e-5) || !approx(n.z, c.normal.z, 1e-5) {
+			t.Errorf("%s: normal is %v, want %v", c.name, n, c.normal)
+		}
+	}
+}
+
+func TestParaboloidClipsDepthRange(t *testing.T) {
+	p, err := NewParaboloid(Vec3{1, 0, 0}, Vec3{1, 0, 0}, 1, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Ray{
+		// Both crossings are in front of zmin or behind zmax.
+		{Vec3{0.5, 5, 0}, Vec3{0, -1, 0}},
+		{Vec3{3, 5, 0}, Vec3{0, -1, 0}},
+		// Through the cut off vertex.
+		{Vec3{-1, 0, 0}, Vec3{1, 0, 0}},
+	} {
+		h := hitinfinity
+		p.Intersect(&h, &r)
+		if h.distance != infinity {
+			t.Errorf("ray %v hit the clipped part at %g", r, h.distance)
+		}
+	}
+	// Inside the range the near wall is hit, and where the near crossing is
+	// clipped the far one is.
+	// The line through near, which is clipped, and far, which is not.
+	near, far := Vec3{0.25, -1, 0}, Vec3{1.5, float32(math.Sqrt(6)), 0}
+	d := normalize(vec3sub(far, near))
+	for _, c := range []struct {
+		r    Ray
+		dist float32
+	}{
+		{Ray{Vec3{1.5, 5, 0}, Vec3{0, -1, 0}}, 5 - float32(math.Sqrt(6))},
+		{Ray{vec3sub(near, d), d}, 1 + sqrtf(vec3dot(vec3sub(far, near), vec3sub(far, near)))},
+	} {
+		h := hitinfinity
+		p.Intersect(&h, &c.r)
+		if !approx(h.distance, c.dist, 1e-4) {
+			t.Errorf("ray %v hit at %g, want %g", c.r, h.distance, c.dist)
+		}
+	}
+}
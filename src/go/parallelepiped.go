@@ -0,0 +1,90 @@
+package main
+
+import errors "errors"
+import fmt "fmt"
+
+// Parallelepiped is the solid spanned by three edges from origin, an
+// arbitrarily oriented and sheared box.
+type Parallelepiped struct {
+	origin Vec3
+	edges  [3]Vec3
+	// The rows of the inverse of the matrix with the edges as columns, so
+	// dot(inv[i], p-origin) is the position of p along edges[i] in [0, 1].
+	// They are perpendicular to the faces as well.
+	inv [3]Vec3
+	// The outward normals of the faces at 1 along each edge.
+	normals [3]Vec3
+}
+
+func NewParallelepiped(origin, e1, e2, e3 Vec3) (*Parallelepiped, error) {
+	c := [3]Vec3{vec3cross(e2, e3), vec3cross(e3, e1), vec3cross(e1, e2)}
+	det := vec3dot(e1, c[0])
+	if det == 0 {
+		return nil, errors.New("parallelepiped edges must not be coplanar")
+	}
+	p := &Parallelepiped{origin: origin, edges: [3]Vec3{e1, e2, e3}}
+	for i := range c {
+		p.inv[i] = vec3mulf(c[i], 1/det)
+		p.normals[i] = normalize(p.inv[i])
+	}
+	return p, nil
+}
+
+// AllHits returns where r enters and leaves the parallelepiped, including
+// hits behind the ray origin, using the slab method in its local frame.
+func (p *Parallelepiped) AllHits(r *Ray) []Hit {
+	o := vec3sub(r.orig, p.origin)
+	near, far := -infinity, infinity
+	var nearFace, farFace Vec3
+	for i, row := range p.inv {
+		lo := vec3dot(row, o)
+		ld := vec3dot(row, r.dir)
+		n := p.normals[i]
+		if ld == 0 {
+			// Parallel to this slab, so entirely inside or outside of it.
+			if lo < 0 || lo > 1 {
+				return nil
+			}
+			continue
+		}
+		t0, t1 := -lo/ld, (1-lo)/ld
+		n0, n1 := vec3mulf(n, -1), n
+		if t0 > t1 {
+			t0, t1 = t1, t0
+			n0, n1 = n1, n0
+		}
+		if t0 > near {
+			near, nearFace = t0, n0
+		}
+		if t1 < far {
+			far, farFace = t1, n1
+		}
+		if near > far {
+			return nil
+		}
+	}
+	return []Hit{{distance: near, pos: nearFace, geom: p}, {distance: far, pos: farFace, geom: p}}
+}
+
+func (p *Parallelepiped) Intersect(h *Hit, r *Ray) {
+	hits := p.AllHits(r)
+	for i, hit := range hits {
+		if hit.distance <= 0 {
+			continue
+		}
+		if hit.distance >= h.distance {
+			return
+		}
+		*h = hit
+		if i == 1 {
+			// Seen from within, the faces point inwards.
+			h.inside = true
+			h.pos = vec3mulf(h.pos, -1)
+		}
+		return
+	}
+}
+
+func (p *Parallelepiped) Print() {
+	fmt.Println("Parallelepiped:", p.origin, p.edges)
+}
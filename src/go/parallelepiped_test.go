@@ -0,0 +1,67 @@
+package main
+
+import testing "testing"
+
+// aabbHits returns where r enters and leaves b, with the slab method on the
+// world axes.
+func aabbHits(b AABB, r Ray) (near, far float32, ok bool) {
+	near, far = -infinity, infinity
+	lo := [3]float32{b.min.x, b.min.y, b.min.z}
+	hi := [3]float32{b.max.x, b.max.y, b.max.z}
+	o := [3]float32{r.orig.x, r.orig.y, r.orig.z}
+	d := [3]float32{r.dir.x, r.dir.y, r.dir.z}
+	for i := range o {
+		if d[i] == 0 {
+			if o[i] < lo[i] || o[i] > hi[i] {
+				return 0, 0, false
+			}
+			continue
+		}
+		t0, t1 := (lo[i]-o[i])/d[i], (hi[i]-o[i])/d[i]
+		near, far = max(near, min(t0, t1)), min(far, max(t0, t1))
+	}
+	return near, far, near <= far
+}
+
+func TestUnitParallelepipedMatchesAABB(t *testing.T) {
+	p, err := NewParallelepiped(Vec3{}, Vec3{1, 0, 0}, Vec3{0, 1, 0}, Vec3{0, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	box := AABB{Vec3{0, 0, 0}, Vec3{1, 1, 1}}
+	dirs := []Vec3{{1, 0, 0}, {-1, 0, 0}, {0, 1, 0}, {0, -1, 0}, {0, 0, 1}, {0, 0, -1}}
+	for _, d := range dirs {
+		for _, u := range []float32{-0.5, 0.1, 0.5, 0.9, 1.5} {
+			for _, v := range []float32{-0.5, 0.25, 0.75, 1.5} {
+				// Start 3 units back along d, offset across it by u, v.
+				var o Vec3
+				switch {
+				case d.x != 0:
+					o = Vec3{0.5 - 3*d.x, u, v}
+				case d.y != 0:
+					o = Vec3{u, 0.5 - 3*d.y, v}
+				default:
+					o = Vec3{u, v, 0.5 - 3*d.z}
+				}
+				r := Ray{o, d}
+				near, far, ok := aabbHits(box, r)
+				hits := p.AllHits(&r)
+				if ok != (len(hits) == 2) {
+					t.Fatalf("ray %v: AABB hit %v, parallelepiped hits %v", r, ok, hits)
+				}
+				if ok && (!approx(hits[0].distance, near, 1e-6) || !approx(hits[1].distance, far, 1e-6)) {
+					t.Errorf("ray %v: parallelepiped hits at %g and %g, AABB at %g and %g", r, hits[0].distance, hits[1].distance, near, far)
+				}
+				if ok && hits[0].pos != vec3mulf(d, -1) {
+					t.Errorf("ray %v: entry normal %v, want %v", r, hits[0].pos, vec3mulf(d, -1))
+				}
+			}
+		}
+	}
+}
+
+func TestParallelepipedRejectsCoplanarEdges(t *testing.T) {
+	if _, err := NewParallelepiped(Vec3{}, Vec3{1, 0, 0}, Vec3{0, 1, 0}, Vec3{1, 1, 0}); err == nil {
+		t.Error("coplanar edges were accepted")
+	}
+}
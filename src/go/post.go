@@ -0,0 +1,301 @@
+package main
+
+import fmt "fmt"
+import math "math"
+import strconv "strconv"
+import strings "strings"
+import sync "sync"
+
+// FloatImage is an image of unclamped colors, with 0, 0 at the top-left.
+type FloatImage struct {
+	w, h int
+	pix  []Vec3
+}
+
+func NewFloatImage(w, h int) *FloatImage {
+	return &FloatImage{w, h, make([]Vec3, w*h)}
+}
+
+func (im *FloatImage) At(x, y int) Vec3 {
+	return im.pix[y*im.w+x]
+}
+
+func (im *FloatImage) Set(x, y int, v Vec3) {
+	im.pix[y*im.w+x] = v
+}
+
+// clampedAt returns the pixel at x, y, or at the nearest edge pixel for
+// positions outside of the image.
+func (im *FloatImage) clampedAt(x, y int) Vec3 {
+	return im.pix[min(max(y, 0), im.h-1)*im.w+min(max(x, 0), im.w-1)]
+}
+
+// Texture returns im converted to bytes.
+func (im *FloatImage) Texture() *Texture {
+	t := NewTexture(im.w, im.h)
+	for i, v := range im.pix {
+		t.SetV(i%im.w, i/im.w, v)
+	}
+	return t
+}
+
+// FloatImage returns t converted to colors in [0, 1].
+func (t *Texture) FloatImage() *FloatImage {
+	im := NewFloatImage(t.w, t.h)
+	for i := range im.pix {
+		im.pix[i] = t.GetV(i%t.w, i/t.w)
+	}
+	return im
+}
+
+// PostStage is a step of post-processing, which changes an image in place.
+type PostStage func(im *FloatImage) error
+
+// ParsePost parses a comma separated list of post-processing stages, each
+// a name and colon separated parameters:
+//
+//	box:r                 box blur of radius r pixels
+//	blur:sigma            gaussian blur with a standard deviation of sigma pixels
+//	unsharp:sigma:amount  sharpen by amount times the difference to a gaussian blur
+//	bloom:threshold:sigma:strength
+//	                      add a halo of gaussian blurred light above threshold
+//	vignette:strength:radius
+//	                      darken towards the corners, beyond radius, by a
+//	                      strength of at most 1
+//	chroma:amount         scale red up and blue down by amount below 1 around
+//	                      the center
+//
+// Filters run on workers goroutines, and treat pixels beyond the edges as
+// copies of the nearest edge pixel.
+func ParsePost(spec string, workers int) ([]PostStage, error) {
+	var stages []PostStage
+	for _, s := range strings.Split(spec, ",") {
+		parts := strings.Split(s, ":")
+		args := make([]float64, len(parts)-1)
+		for i, p := range parts[1:] {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid parameter %q of post stage %q", p, s)
+			}
+			args[i] = v
+		}
+		want := map[string]int{"box": 1, "blur": 1, "unsharp": 2, "bloom": 3, "vignette": 2, "chroma": 1}[parts[0]]
+		if want == 0 {
+			return nil, fmt.Errorf("unknown post stage %q", parts[0])
+		}
+		if len(args) != want {
+			return nil, fmt.Errorf("post stage %q takes %d parameters, not %d", parts[0], want, len(args))
+		}
+		switch parts[0] {
+		case "box":
+			stages = append(stages, BoxBlur(int(args[0]), workers))
+		case "blur":
+			stages = append(stages, GaussianBlur(float32(args[0]), workers))
+		case "unsharp":
+			stages = append(stages, UnsharpMask(float32(args[0]), float32(args[1]), workers))
+		case "bloom":
+			stages = append(stages, Bloom(float32(args[0]), float32(args[1]), float32(args[2]), workers))
+		case "vignette":
+			if args[0] > 1 {
+				return nil, fmt.Errorf("strength of post stage %q is above 1, which would turn colors negative", s)
+			}
+			stages = append(stages, Vignette(float32(args[0]), float32(args[1])))
+		case "chroma":
+			if args[0] >= 1 {
+				return nil, fmt.Errorf("amount of post stage %q must be below 1", s)
+			}
+			stages = append(stages, ChromaticAberration(float32(args[0])))
+		}
+	}
+	return stages, nil
+}
+
+// RunPost applies the stages to im in order, stopping at the first error.
+func RunPost(im *FloatImage, stages []PostStage) error {
+	for _, s := range stages {
+		if err := s(im); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func BoxBlur(radius, workers int) PostStage {
+	k := make([]float32, 2*radius+1)
+	for i := range k {
+		k[i] = 1 / float32(len(k))
+	}
+	return func(im *FloatImage) error {
+		convolve(im, k, workers)
+		return nil
+	}
+}
+
+func GaussianBlur(sigma float32, workers int) PostStage {
+	k := gaussianKernel(sigma)
+	return func(im *FloatImage) error {
+		convolve(im, k, workers)
+		return nil
+	}
+}
+
+// UnsharpMask sharpens by adding amount times the difference of each pixel
+// to its gaussian blurred surroundings.
+func UnsharpMask(sigma, amount float32, workers int) PostStage {
+	k := gaussianKernel(sigma)
+	return func(im *FloatImage) error {
+		blurred := &FloatImage{im.w, im.h, append([]Vec3(nil), im.pix...)}
+		convolve(blurred, k, workers)
+		for i, v := range im.pix {
+			im.pix[i] = vec3add(v, vec3mulf(vec3sub(v, blurred.pix[i]), amount))
+		}
+		return nil
+	}
+}
+
+// Bloom makes highlights glow, by blurring the part of each color component
+// above threshold and adding strength times the result. It expects linear
+// colors, so it needs to run before any tone mapping.
+func Bloom(threshold, sigma, strength float32, workers int) PostStage {
+	k := gaussianKernel(sigma)
+	return func(im *FloatImage) error {
+		bright := NewFloatImage(im.w, im.h)
+		for i, v := range im.pix {
+			bright.pix[i] = Vec3{max(v.x-threshold, 0), max(v.y-threshold, 0), max(v.z-threshold, 0)}
+		}
+		convolve(bright, k, workers)
+		for i, v := range im.pix {
+			im.pix[i] = vec3add(v, vec3mulf(bright.pix[i], strength))
+		}
+		return nil
+	}
+}
+
+// Vignette darkens the image towards its corners. Within radius of the
+// center, in units of the distance to the corners, colors are kept, and
+// beyond it they fall off quadratically to 1-strength times their value at
+// the corners, so strength must not be above 1.
+func Vignette(strength, radius float32) PostStage {
+	return func(im *FloatImage) error {
+		cx, cy := float32(im.w)/2, float32(im.h)/2
+		corner := sqrtf(cx*cx + cy*cy)
+		for y := 0; y < im.h; y++ {
+			for x := 0; x < im.w; x++ {
+				dx, dy := float32(x)+0.5-cx, float32(y)+0.5-cy
+				d := sqrtf(dx*dx+dy*dy) / corner
+				if d <= radius {
+					continue
+				}
+				f := (d - radius) / (1 - radius)
+				im.Set(x, y, vec3mulf(im.At(x, y), 1-strength*f*f))
+			}
+		}
+		return nil
+	}
+}
+
+// ChromaticAberration imitates lenses which magnify red more than blue, by
+// scaling the red channel by 1+amount around the image center and the blue
+// one by 1-amount. Green stays in place. amount must be below 1.
+func ChromaticAberration(amount float32) PostStage {
+	return func(im *FloatImage) error {
+		src := &FloatImage{im.w, im.h, append([]Vec3(nil), im.pix...)}
+		cx, cy := float32(im.w)/2, float32(im.h)/2
+		for y := 0; y < im.h; y++ {
+			for x := 0; x < im.w; x++ {
+				dx, dy := float32(x)+0.5-cx, float32(y)+0.5-cy
+				r := src.bilinearAt(cx+dx/(1+amount), cy+dy/(1+amount))
+				b := src.bilinearAt(cx+dx/(1-amount), cy+dy/(1-amount))
+				im.pix[y*im.w+x].x = r.x
+				im.pix[y*im.w+x].z = b.z
+			}
+		}
+		return nil
+	}
+}
+
+// bilinearAt interpolates im at the continuous position x, y, where pixel
+// centers are at half-integer positions.
+func (im *FloatImage) bilinearAt(x, y float32) Vec3 {
+	x, y = x-0.5, y-0.5
+	x0, y0 := int(math.Floor(float64(x))), int(math.Floor(float64(y)))
+	fx, fy := x-float32(x0), y-float32(y0)
+	top := vec3add(vec3mulf(im.clampedAt(x0, y0), 1-fx), vec3mulf(im.clampedAt(x0+1, y0), fx))
+	bottom := vec3add(vec3mulf(im.clampedAt(x0, y0+1), 1-fx), vec3mulf(im.clampedAt(x0+1, y0+1), fx))
+	return vec3add(vec3mulf(top, 1-fy), vec3mulf(bottom, fy))
+}
+
+// Gamma encodes the colors of the image with gammaEncode, which needs to be
+// the last stage as all others expect linear colors.
+func Gamma(gamma float32) PostStage {
+	return func(im *FloatImage) error {
+		for i, v := range im.pix {
+			im.pix[i] = gammaEncode(v, gamma)
+		}
+		return nil
+	}
+}
+
+// gaussianKernel returns normalized weights reaching three standard
+// deviations to each side.
+func gaussianKernel(sigma float32) []float32 {
+	if sigma <= 0 {
+		return []float32{1}
+	}
+	r := int(math.Ceil(float64(3 * sigma)))
+	k := make([]float32, 2*r+1)
+	var sum float32
+	for i := range k {
+		d := float32(i - r)
+		k[i] = float32(math.Exp(float64(-d * d / (2 * sigma * sigma))))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// convolve filters im with the separable, odd sized kernel k horizontally
+// and then vertically.
+func convolve(im *FloatImage, k []float32, workers int) {
+	r := len(k) / 2
+	tmp := NewFloatImage(im.w, im.h)
+	parallelRows(im.h, workers, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < im.w; x++ {
+				var s Vec3
+				for i, w := range k {
+					s = vec3add(s, vec3mulf(im.clampedAt(x+i-r, y), w))
+				}
+				tmp.Set(x, y, s)
+			}
+		}
+	})
+	parallelRows(im.h, workers, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < im.w; x++ {
+				var s Vec3
+				for i, w := range k {
+					s = vec3add(s, vec3mulf(tmp.clampedAt(x, y+i-r), w))
+				}
+				im.Set(x, y, s)
+			}
+		}
+	})
+}
+
+// parallelRows calls f for bands of the rows 0 to h, using up to workers
+// goroutines, and waits for all of them.
+func parallelRows(h, workers int, f func(y0, y1 int)) {
+	workers = max(1, min(workers, h))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			f(y0, y1)
+		}(h*i/workers, h*(i+1)/workers)
+	}
+	wg.Wait()
+}
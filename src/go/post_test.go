@@ -0,0 +1,195 @@
+package main
+
+import math "math"
+import testing "testing"
+
+// rampImage returns a w x h image whose channels all rise from 0 at the left
+// to 1 at the right edge.
+func rampImage(w, h int) *FloatImage {
+	im := NewFloatImage(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := float32(x) / float32(w-1)
+			im.Set(x, y, Vec3{v, v, v})
+		}
+	}
+	return im
+}
+
+func TestParsePostRejectsOutOfRange(t *testing.T) {
+	for _, spec := range []string{"chroma:1", "chroma:2", "vignette:1.5:0.5"} {
+		if _, err := ParsePost(spec, 1); err == nil {
+			t.Errorf("ParsePost(%q) succeeded", spec)
+		}
+	}
+	for _, spec := range []string{"chroma:0.99", "vignette:1:0.5"} {
+		if _, err := ParsePost(spec, 1); err != nil {
+			t.Errorf("ParsePost(%q): %v", spec, err)
+		}
+	}
+}
+
+func TestChromaticAberrationKeepsCenter(t *testing.T) {
+	im := rampImage(33, 33)
+	want := im.At(16, 16)
+	if err := ChromaticAberration(0.1)(im); err != nil {
+		t.Fatal(err)
+	}
+	if got := im.At(16, 16); got != want {
+		t.Errorf("center changed from %v to %v", want, got)
+	}
+}
+
+func TestChromaticAberrationShiftsCorners(t *testing.T) {
+	src := rampImage(33, 33)
+	im := rampImage(33, 33)
+	if err := ChromaticAberration(0.1)(im); err != nil {
+		t.Fatal(err)
+	}
+	// Right of the center, red comes from nearer the center and blue from
+	// further out, so along the ramp red darkens and blue brightens.
+	want, got := src.At(30, 2), im.At(30, 2)
+	if got.x >= want.x {
+		t.Errorf("red at the corner is %g, want below %g", got.x, want.x)
+	}
+	if got.z <= want.z {
+		t.Errorf("blue at the corner is %g, want above %g", got.z, want.z)
+	}
+	if got.y != want.y {
+		t.Errorf("green at the corner changed from %g to %g", want.y, got.y)
+	}
+}
+
+func TestVignetteKeepsCenterDarkensCorners(t *testing.T) {
+	im := NewFloatImage(32, 24)
+	for i := range im.pix {
+		im.pix[i] = Vec3{1, 1, 1}
+	}
+	if err := Vignette(1, 0.5)(im); err != nil {
+		t.Fatal(err)
+	}
+	if c := im.At(16, 12); c != (Vec3{1, 1, 1}) {
+		t.Errorf("center is %v, want it unchanged", c)
+	}
+	if c := im.At(0, 0); c.x >= 0.2 || c.x < 0 {
+		t.Errorf("corner is %v, want it darkened below 0.2", c)
+	}
+}
+
+func TestBloomSpreadsPointBySigma(t *testing.T) {
+	const size, sigma, strength = 41, 3, 0.5
+	c := size / 2
+	im := NewFloatImage(size, size)
+	im.Set(c, c, Vec3{11, 11, 11})
+	if err := Bloom(1, sigma, strength, 2)(im); err != nil {
+		t.Fatal(err)
+	}
+	// The light added around the point should be the 10 above the threshold
+	// times strength, with a variance of sigma^2 along each axis, less a
+	// little for the kernel being cut off at 3 sigma.
+	var total, mx, my float64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			added := float64(im.At(x, y).x)
+			if x == c && y == c {
+				added -= 11
+			}
+			total += added
+			mx += added * float64((x-c)*(x-c))
+			my += added * float64((y-c)*(y-c))
+		}
+	}
+	if math.Abs(total-10*strength) > 1e-3 {
+		t.Errorf("bloom added %g, want %g", total, 10*strength)
+	}
+	for _, m := range []float64{mx / total, my / total} {
+		if m < 0.95*sigma*sigma || m > sigma*sigma {
+			t.Errorf("halo variance is %g, want about %g", m, float64(sigma*sigma))
+		}
+	}
+}
+
+func TestFiltersKeepConstantImage(t *testing.T) {
+	for name, f := range map[string]PostStage{
+		"box":     BoxBlur(2, 2),
+		"blur":    GaussianBlur(1.5, 2),
+		"unsharp": UnsharpMask(1.5, 1, 2),
+	} {
+		im := NewFloatImage(9, 7)
+		for i := range im.pix {
+			im.pix[i] = Vec3{0.3, 0.5, 0.7}
+		}
+		if err := f(im); err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range im.pix {
+			if !approx(v.x, 0.3, 1e-5) || !approx(v.y, 0.5, 1e-5) || !approx(v.z, 0.7, 1e-5) {
+				t.Errorf("%s changed pixel %d, %d of a constant image to %v", name, i%im.w, i/im.w, v)
+				break
+			}
+		}
+	}
+}
+
+func TestBlursSpreadPointByKernel(t *testing.T) {
+	const size = 15
+	c := size / 2
+	box := make([]float32, 3)
+	for i := range box {
+		box[i] = 1.0 / 3
+	}
+	for name, tc := range map[string]struct {
+		f PostStage
+		k []float32
+	}{
+		"box":  {BoxBlur(1, 2), box},
+		"blur": {GaussianBlur(1, 2), gaussianKernel(1)},
+	} {
+		im := NewFloatImage(size, size)
+		im.Set(c, c, Vec3{1, 1, 1})
+		if err := tc.f(im); err != nil {
+			t.Fatal(err)
+		}
+		// The blur is separable, so the weight of each pixel is the product
+		// of the kernel weights of its offsets along x and y.
+		r := len(tc.k) / 2
+		weight := func(d int) float32 {
+			if d < -r || d > r {
+				return 0
+			}
+			return tc.k[d+r]
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if v, want := im.At(x, y).x, weight(x-c)*weight(y-c); !approx(v, want, 1e-6) {
+					t.Errorf("%s: pixel %d, %d is %g, want %g", name, x, y, v, want)
+				}
+			}
+		}
+	}
+}
+
+func TestUnsharpMaskSteepensEdge(t *testing.T) {
+	const w, lo, hi = 20, 0.2, 0.8
+	im := NewFloatImage(w, 3)
+	for i := range im.pix {
+		v := float32(lo)
+		if i%w >= w/2 {
+			v = hi
+		}
+		im.pix[i] = Vec3{v, v, v}
+	}
+	if err := UnsharpMask(1, 1, 2)(im); err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < im.h; y++ {
+		// The pixels next to the edge overshoot away from it, while those
+		// beyond the kernel radius keep their values.
+		if l, r := im.At(w/2-1, y).x, im.At(w/2, y).x; l >= lo || r <= hi {
+			t.Errorf("edge in row %d goes from %g to %g, want steeper than %g to %g", y, l, r, float32(lo), float32(hi))
+		}
+		if l, r := im.At(0, y).x, im.At(w-1, y).x; !approx(l, lo, 1e-6) || !approx(r, hi, 1e-6) {
+			t.Errorf("far pixels in row %d are %g and %g, want %g and %g", y, l, r, float32(lo), float32(hi))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import fmt "fmt"
+import io "io"
+import os "os"
+import strings "strings"
+
+// Size used if the terminal can't tell us its own.
+const defaultTermCols, defaultTermRows = 80, 24
+
+// PreviewTerm prints a downsampled version of t to w using half-block
+// characters, so every character cell shows two vertically stacked pixels.
+// It uses 24-bit colors if the terminal advertises them, and the 256-color
+// palette otherwise.
+func (t *Texture) PreviewTerm(w io.Writer, cols, rows int, truecolor bool) {
+	// Leave one line for the prompt, and two pixels per character cell.
+	prows := 2 * (rows - 1)
+	if cols < 1 || prows < 2 {
+		return
+	}
+	scale := float32(t.w) / float32(cols)
+	if s := float32(t.h) / float32(prows); s > scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	pw := int(float32(t.w) / scale)
+	ph := int(float32(t.h)/scale) &^ 1
+
+	var sb strings.Builder
+	for y := 0; y < ph; y += 2 {
+		for x := 0; x < pw; x++ {
+			tr, tg, tb := t.boxAverage(x, y, scale)
+			br, bg, bb := t.boxAverage(x, y+1, scale)
+			if truecolor {
+				fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm", tr, tg, tb, br, bg, bb)
+			} else {
+				fmt.Fprintf(&sb, "\x1b[38;5;%dm\x1b[48;5;%dm", ansi256(tr, tg, tb), ansi256(br, bg, bb))
+			}
+			sb.WriteString("▀")
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	io.WriteString(w, sb.String())
+}
+
+// boxAverage returns the mean color of the scale x scale block of source
+// pixels which makes up the preview pixel at x, y.
+func (t *Texture) boxAverage(x, y int, scale float32) (byte, byte, byte) {
+	x0, y0 := int(float32(x)*scale), int(float32(y)*scale)
+	x1, y1 := int(float32(x+1)*scale), int(float32(y+1)*scale)
+	if x1 > t.w {
+		x1 = t.w
+	}
+	if y1 > t.h {
+		y1 = t.h
+	}
+	var r, g, b, n int
+	for sy := y0; sy < y1; sy++ {
+		o := 4 * (t.w*sy + x0)
+		for sx := x0; sx < x1; sx++ {
+			r += int(t.buf[o])
+			g += int(t.buf[o+1])
+			b += int(t.buf[o+2])
+			n++
+			o += 4
+		}
+	}
+	if n == 0 {
+		return 0, 0, 0
+	}
+	return byte(r / n), byte(g / n), byte(b / n)
+}
+
+// ansi256 maps a color onto the 6x6x6 color cube of the 256-color palette.
+func ansi256(r, g, b byte) int {
+	q := func(c byte) int { return (int(c)*5 + 127) / 255 }
+	return 16 + 36*q(r) + 6*q(g) + q(b)
+}
+
+// previewTerm prints t to stdout, sized to fit the terminal.
+func previewTerm(t *Texture) {
+	cols, rows, ok := termSize(os.Stdout)
+	if !ok {
+		cols, rows = defaultTermCols, defaultTermRows
+	}
+	ct := os.Getenv("COLORTERM")
+	t.PreviewTerm(os.Stdout, cols, rows, ct == "truecolor" || ct == "24bit")
+}
@@ -0,0 +1,100 @@
+package main
+
+import atomic "sync/atomic"
+import time "time"
+
+// Progress describes how far a render has come.
+type Progress struct {
+	tilesDone, tilesTotal int
+	elapsed               time.Duration
+	remaining             time.Duration // estimated from the time taken so far
+}
+
+func (p Progress) Fraction() float32 {
+	if p.tilesTotal == 0 {
+		return 1
+	}
+	return float32(p.tilesDone) / float32(p.tilesTotal)
+}
+
+// WithProgress makes Render call f whenever tiles were completed. f is always
+// called from the same goroutine, and updates are coalesced while it runs so
+// a slow callback can't hold up the workers. The last call reports all
+// tiles done, unless the render was cancelled, when it reports the tiles
+// which were done by then.
+func (ren *Renderer) WithProgress(f func(p Progress)) *Renderer {
+	ren.onProgress = f
+	return ren
+}
+
+// ProgressUpdates returns a channel receiving the progress of the next
+// render, which is closed when it ends, completed or cancelled. Updates the
+// receiver hasn't picked up yet are replaced by newer ones, so it can't hold
+// up the render.
+func (ren *Renderer) ProgressUpdates() <-chan Progress {
+	ch := make(chan Progress, 1)
+	ren.progressUpdates = ch
+	ren.onProgress = func(p Progress) {
+		select {
+		case ch <- p:
+		default:
+			// Drop the stale update. Only this goroutine sends, so there
+			// is room afterwards.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- p
+		}
+	}
+	return ch
+}
+
+// Completed returns how far the last render came, which is short of all
+// tiles if it was cancelled.
+func (ren *Renderer) Completed() Progress {
+	return Progress{tilesDone: int(atomic.LoadInt64(&ren.tilesDone)), tilesTotal: ren.tilesTotal, elapsed: ren.stats.elapsed}
+}
+
+func (ren *Renderer) progress(start time.Time) Progress {
+	p := Progress{tilesDone: int(atomic.LoadInt64(&ren.tilesDone)), tilesTotal: ren.tilesTotal, elapsed: time.Since(start)}
+	if p.tilesDone > 0 {
+		p.remaining = time.Duration(float64(p.elapsed) * float64(p.tilesTotal-p.tilesDone) / float64(p.tilesDone))
+	}
+	return p
+}
+
+// tileDone is called by workers for every completed tile.
+func (ren *Renderer) tileDone() {
+	done := atomic.AddInt64(&ren.tilesDone, 1)
+	if ren.OutputEveryN > 0 && done%int64(ren.OutputEveryN) == 0 {
+		ren.checkpoint(int(done))
+	}
+	if ren.progressChan == nil {
+		return
+	}
+	// If the reporter is still busy with an earlier update it will pick up
+	// this tile with the next one.
+	select {
+	case ren.progressChan <- true:
+	default:
+	}
+}
+
+// reportProgress calls the progress callback until progressChan is closed.
+func (ren *Renderer) reportProgress(start time.Time, done chan<- bool) {
+	last := -1
+	for range ren.progressChan {
+		p := ren.progress(start)
+		if p.tilesDone == last {
+			// Already reported along with an earlier notification.
+			continue
+		}
+		ren.onProgress(p)
+		last = p.tilesDone
+	}
+	if last != ren.tilesTotal {
+		ren.onProgress(ren.progress(start))
+	}
+	done <- true
+}
@@ -0,0 +1,60 @@
+package main
+
+import context "context"
+import testing "testing"
+import time "time"
+
+func TestProgressUpdatesCloseAfterCancel(t *testing.T) {
+	ren := pyramidRenderer(256, 192, 6, 2)
+	ren.workers = 1
+	updates := ren.ProgressUpdates()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		// Ranging ends only once the channel is closed.
+		last := -1
+		for p := range updates {
+			if last < 0 {
+				cancel()
+			}
+			last = p.tilesDone
+		}
+		done <- last
+	}()
+	if err := ren.Render(ctx); err != context.Canceled {
+		t.Errorf("Render returned %v, want %v", err, context.Canceled)
+	}
+	select {
+	case last := <-done:
+		if last >= ren.tilesTotal {
+			t.Errorf("last update reports %d of %d tiles after cancelling", last, ren.tilesTotal)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("consumer of progress updates didn't exit after cancelling")
+	}
+}
+
+func TestProgressIsMonotonic(t *testing.T) {
+	ren := pyramidRenderer(128, 96, 4, 1)
+	ren.workers = 4
+	var reports []Progress
+	ren.WithProgress(func(p Progress) {
+		// Slow callbacks get coalesced updates rather than stalling workers.
+		time.Sleep(100 * time.Microsecond)
+		reports = append(reports, p)
+	})
+	if err := ren.Render(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) == 0 {
+		t.Fatal("no progress was reported")
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Fraction() <= reports[i-1].Fraction() {
+			t.Errorf("progress went from %g to %g", reports[i-1].Fraction(), reports[i].Fraction())
+		}
+	}
+	if last := reports[len(reports)-1]; last.Fraction() != 1 {
+		t.Errorf("last report is at %g, want 1", last.Fraction())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// countNodes returns the amount of groups and other geometry in g.
+func countNodes(g Geometry) (groups, leaves int) {
+	grp, ok := g.(*Group)
+	if !ok {
+		return 0, 1
+	}
+	groups = 1
+	for _, c := range grp.children {
+		cg, cl := countNodes(c)
+		groups += cg
+		leaves += cl
+	}
+	return groups, leaves
+}
+
+// Prune returns g without the spheres which appear smaller than minRadius
+// pixels in radius to cam, and without groups left empty by that. Unlike
+// LOD nodes, which keep all levels and choose while tracing, this drops the
+// spheres from the scene, and returns nil if nothing is left.
+func Prune(g Geometry, cam *Camera, minRadius float32) Geometry {
+	switch g := g.(type) {
+	case *Sphere:
+		d := vec3sub(g.center, cam.eye)
+		// Compare squares, as size = radius*focal/dist.
+		rf := g.radius * cam.focal
+		if rf*rf < minRadius*minRadius*vec3dot(d, d) {
+			return nil
+		}
+	case *Group:
+		var children []Geometry
+		changed := false
+		for _, c := range g.children {
+			p := Prune(c, cam, minRadius)
+			if p != nil {
+				children = append(children, p)
+			}
+			changed = changed || p != c
+		}
+		switch {
+		case len(children) == 0:
+			return nil
+		case !changed:
+			return g
+		case len(children) == 1:
+			// The bound test of a single child is no cheaper than its own.
+			return children[0]
+		}
+		return NewGroup(g.bound, children)
+	}
+	return g
+}
@@ -0,0 +1,59 @@
+package main
+
+import filepath "path/filepath"
+import strings "strings"
+import testing "testing"
+
+func TestPruneDropsSmallestLevel(t *testing.T) {
+	const w, h = 160, 120
+	// Far enough for the projected sizes of each level not to overlap, so
+	// the smallest spheres are between 1.5 and 2 pixels in radius and all
+	// others at least 3.
+	cam := NewCamera(Vec3{0, 0, -12}, w, h)
+	g := createSpherePyramid(4, Vec3{0.0, -1.0, 0.0}, 1.0)
+	pruned := Prune(g, cam, 2.5)
+	if groups, leaves := countNodes(pruned); groups != 5 || leaves != 21 {
+		t.Fatalf("pruned pyramid has %d groups and %d leaves, want the 5 and 21 of a smaller pyramid", groups, leaves)
+	}
+	render := func(g Geometry) *Texture {
+		scene := mustScene(Vec3{-1.0, -3.0, 2.0}, g, SolidBackground(backgroundColor))
+		return mustRender(t, NewRenderer(scene, NewTexture(w, h), cam, 1))
+	}
+	full, small := render(g), render(pruned)
+	n := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if pixelDiff(full, small, x, y) > 0.1 {
+				n++
+			}
+		}
+	}
+	if n > w*h/50 {
+		t.Errorf("%d of %d pixels differ after pruning, want at most 2%%", n, w*h)
+	}
+}
+
+func TestPruneEverythingRendersBackground(t *testing.T) {
+	dir := t.TempDir()
+	code, out := runMain(t, dir, "-width", "32", "-height", "24", "-prune", "1000", "-o", "empty.tga")
+	if code != 0 {
+		t.Fatalf("render failed: %s", out)
+	}
+	if !strings.Contains(out, "warning") {
+		t.Errorf("pruning everything didn't warn: %s", out)
+	}
+	img, err := loadImage(filepath.Join(dir, "empty.tga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewTexture(1, 1)
+	want.SetV(0, 0, backgroundColor)
+	wr, wg, wb, _ := want.GetRgba(0, 0)
+	for y := 0; y < img.h; y++ {
+		for x := 0; x < img.w; x++ {
+			if r, g, b, _ := img.GetRgba(x, y); r != wr || g != wg || b != wb {
+				t.Fatalf("pixel %d, %d is %d, %d, %d, not the background", x, y, r, g, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import rand "math/rand"
+
+// AABB is an axis aligned box spanning from min to max.
+type AABB struct {
+	min, max Vec3
+}
+
+// randomPoint returns a point uniformly distributed in b.
+func (b AABB) randomPoint(rng *rand.Rand) Vec3 {
+	return Vec3{
+		b.min.x + rng.Float32()*(b.max.x-b.min.x),
+		b.min.y + rng.Float32()*(b.max.y-b.min.y),
+		b.min.z + rng.Float32()*(b.max.z-b.min.z),
+	}
+}
+
+// randomSphereAttempts is how often RandomSpheres tries to place each sphere
+// before giving up on it.
+const randomSphereAttempts = 100
+
+// RandomSpheres returns a group of up to n spheres which don't overlap, with
+// centers uniformly distributed in bound and radii in [rMin, rMax]. Spheres
+// which can't be placed are left out, and nil is returned if none could be.
+func RandomSpheres(n int, bound AABB, rMin, rMax float32, rng *rand.Rand) *Group {
+	var spheres []*Sphere
+	for i := 0; i < n; i++ {
+	attempt:
+		for a := 0; a < randomSphereAttempts; a++ {
+			s := &Sphere{bound.randomPoint(rng), rMin + rng.Float32()*(rMax-rMin)}
+			for _, o := range spheres {
+				d := vec3sub(s.center, o.center)
+				if r := s.radius + o.radius; vec3dot(d, d) < r*r {
+					continue attempt
+				}
+			}
+			spheres = append(spheres, s)
+			break
+		}
+	}
+	if len(spheres) == 0 {
+		return nil
+	}
+	children := make([]Geometry, len(spheres))
+	for i, s := range spheres {
+		children[i] = s
+	}
+	return NewGroup(boundingSphere(spheres), children)
+}
@@ -0,0 +1,34 @@
+package main
+
+import rand "math/rand"
+import testing "testing"
+
+func TestRandomSpheresPlacesMostWithoutOverlap(t *testing.T) {
+	const n = 100
+	bound := AABB{Vec3{-50, -50, -50}, Vec3{50, 50, 50}}
+	g := RandomSpheres(n, bound, 0.5, 2, rand.New(rand.NewSource(1)))
+	if g == nil {
+		t.Fatal("no spheres were placed")
+	}
+	if len(g.children) < 0.9*n {
+		t.Fatalf("placed %d of %d spheres, want at least 90%%", len(g.children), n)
+	}
+	for i, a := range g.children {
+		s := a.(*Sphere)
+		if s.radius < 0.5 || s.radius > 2 {
+			t.Errorf("sphere %d has radius %g outside of [0.5, 2]", i, s.radius)
+		}
+		// The group's bound contains every sphere.
+		d := vec3sub(s.center, g.bound.center)
+		if sqrtf(vec3dot(d, d))+s.radius > g.bound.radius*(1+1e-6) {
+			t.Errorf("sphere %d reaches outside of the group bound", i)
+		}
+		for j, b := range g.children[:i] {
+			o := b.(*Sphere)
+			d := vec3sub(s.center, o.center)
+			if r := s.radius + o.radius; vec3dot(d, d) < r*r {
+				t.Errorf("spheres %d and %d overlap", j, i)
+			}
+		}
+	}
+}
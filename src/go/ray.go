@@ -0,0 +1,49 @@
+package main
+
+import fmt "fmt"
+import io "io"
+import strconv "strconv"
+import strings "strings"
+
+// MarshalText encodes r as "orig:(x,y,z) dir:(x,y,z)", with just enough
+// digits to read back the same float32 values.
+func (r Ray) MarshalText() ([]byte, error) {
+	return []byte("orig:" + formatVec3(r.orig) + " dir:" + formatVec3(r.dir)), nil
+}
+
+// UnmarshalText decodes a ray in the form written by MarshalText. The
+// direction is taken as it is, without normalizing it.
+func (r *Ray) UnmarshalText(b []byte) error {
+	var o, d Vec3
+	if _, err := fmt.Sscanf(string(b), "orig:(%g,%g,%g) dir:(%g,%g,%g)", &o.x, &o.y, &o.z, &d.x, &d.y, &d.z); err != nil {
+		return fmt.Errorf("invalid ray %q: %v", b, err)
+	}
+	*r = Ray{o, d}
+	return nil
+}
+
+func formatVec3(v Vec3) string {
+	f := func(c float32) string { return strconv.FormatFloat(float64(c), 'g', -1, 32) }
+	return "(" + f(v.x) + "," + f(v.y) + "," + f(v.z) + ")"
+}
+
+// TraceRay shades r like a primary ray, and returns a trace of every ray
+// cast for it.
+func (ren *Renderer) TraceRay(r Ray) *PixelTrace {
+	pt := &PixelTrace{x: -1, y: -1}
+	pt.color = ren.scene.trace(&r, nil, pt)
+	return pt
+}
+
+// printDebugRay prints the trace of DebugRay to w.
+func (ren *Renderer) printDebugRay(w io.Writer) {
+	text, _ := ren.DebugRay.MarshalText()
+	pt := ren.TraceRay(*ren.DebugRay)
+	fmt.Fprintf(w, "Ray %s: %v\n", text, pt.color)
+	pt.printEvents(w)
+}
+
+// geomName returns the type name of g, such as Sphere.
+func geomName(g Geometry) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", g), "*main.")
+}
@@ -0,0 +1,78 @@
+package main
+
+import testing "testing"
+
+func TestRayTextRoundTrip(t *testing.T) {
+	for _, r := range []Ray{
+		{Vec3{0, 0, -4}, Vec3{0, 0, 1}},
+		{Vec3{0.1, -1.0 / 3, 3.4e38}, normalize(Vec3{-1, -3, 2})},
+		{Vec3{1e-7, -0, 123456.79}, Vec3{1.1754944e-38, 0.5, -2}},
+	} {
+		text, err := r.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Ray
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatalf("UnmarshalText(%q): %v", text, err)
+		}
+		if got != r {
+			t.Errorf("%q read back as %v, want %v", text, got, r)
+		}
+	}
+}
+
+func TestRayUnmarshalTextRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"", "orig:(1,2) dir:(0,0,1)", "dir:(0,0,1) orig:(0,0,0)", "orig:(a,b,c) dir:(0,0,1)"} {
+		var r Ray
+		if err := r.UnmarshalText([]byte(s)); err == nil {
+			t.Errorf("UnmarshalText(%q) succeeded with %v", s, r)
+		}
+	}
+}
+
+func TestRayAt(t *testing.T) {
+	r := Ray{Vec3{1, 2, 3}, Vec3{0, -0.5, 2}}
+	for _, tc := range []struct {
+		t    float32
+		want Vec3
+	}{
+		{0, Vec3{1, 2, 3}},
+		{1, Vec3{1, 1.5, 5}},
+		{4, Vec3{1, 0, 11}},
+		{-2, Vec3{1, 3, -1}},
+	} {
+		if got := r.At(tc.t); got != tc.want {
+			t.Errorf("At(%g) = %v, want %v", tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestRayOffset(t *testing.T) {
+	r := Ray{Vec3{1, 2, 3}, Vec3{0, 0, 1}}
+	got := r.Offset(Vec3{0, 1, 0}, 0.25)
+	if want := (Ray{Vec3{1, 2.25, 3}, Vec3{0, 0, 1}}); got != want {
+		t.Errorf("Offset = %v, want %v", got, want)
+	}
+	if r.orig != (Vec3{1, 2, 3}) {
+		t.Errorf("Offset moved the original ray to %v", r.orig)
+	}
+}
+
+func TestOffsetShadowRayMissesItsSphere(t *testing.T) {
+	s := &Sphere{Vec3{0, 0, 0}, 1}
+	eye := Ray{Vec3{0.3, 0.2, -4}, normalize(Vec3{-0.1, 0, 1})}
+	var h Hit = hitinfinity
+	s.Intersect(&h, &eye)
+	if h.distance == infinity {
+		t.Fatal("ray missed the sphere")
+	}
+	// Towards the light, tangentially to the surface, where rounding is most
+	// likely to hit it again.
+	light := normalize(vec3cross(h.pos, Vec3{0, 1, 0}))
+	sr := Ray{eye.At(h.distance), light}
+	sr = sr.Offset(h.pos, delta)
+	if sh, ok := s.NearestHit(&sr); ok {
+		t.Errorf("offset shadow ray hit its own sphere at %g", sh.distance)
+	}
+}
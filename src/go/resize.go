@@ -0,0 +1,149 @@
+package main
+
+import fmt "fmt"
+import math "math"
+
+// maxSSAAPixels limits the size of -ssaa renders. At 16 bytes per pixel for
+// the texture and the float image, that's 1GiB, which -ssaa 4 reaches at
+// 2048x2048.
+const maxSSAAPixels = 64 << 20
+
+// ResizeFilter selects the reconstruction filter used by Resize.
+type ResizeFilter int
+
+const (
+	FilterBox      ResizeFilter = iota // the average of the covered pixels
+	FilterLanczos3                     // a sinc windowed by a sinc 3 times as wide
+	FilterNearest                      // the source pixel nearest to the center
+	FilterBilinear                     // linear interpolation of the 2 nearest pixels per axis
+)
+
+func parseResizeFilter(s string) (ResizeFilter, error) {
+	switch s {
+	case "box":
+		return FilterBox, nil
+	case "lanczos":
+		return FilterLanczos3, nil
+	case "nearest":
+		return FilterNearest, nil
+	case "bilinear":
+		return FilterBilinear, nil
+	}
+	return FilterBox, fmt.Errorf("unknown resize filter %q", s)
+}
+
+// radius returns how far from its center the filter's weights are non-zero,
+// in units of destination pixels.
+func (f ResizeFilter) radius() float64 {
+	switch f {
+	case FilterLanczos3:
+		return 3
+	case FilterBilinear:
+		return 1
+	}
+	return 0.5
+}
+
+func (f ResizeFilter) weight(x float64) float64 {
+	switch f {
+	case FilterLanczos3:
+		if x <= -3 || x >= 3 {
+			return 0
+		}
+		return sinc(x) * sinc(x/3)
+	case FilterBilinear:
+		return math.Max(1-math.Abs(x), 0)
+	}
+	if x < -0.5 || x >= 0.5 {
+		return 0
+	}
+	return 1
+}
+
+// widens reports whether f covers all source pixels when shrinking. Nearest
+// and bilinear sampling only ever look at the pixels closest to the center.
+func (f ResizeFilter) widens() bool {
+	return f == FilterBox || f == FilterLanczos3
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// contribution is the part of a source row or column which makes up one
+// destination pixel.
+type contribution struct {
+	first   int // the first source pixel, which may lie outside the image
+	weights []float32
+}
+
+// contributions returns the source spans of each of n destination pixels
+// resampled from src pixels. When shrinking, box and Lanczos filters are
+// widened to cover all source pixels, which is what keeps fine patterns from
+// aliasing.
+func contributions(src, n int, f ResizeFilter) []contribution {
+	scale := float64(src) / float64(n)
+	width := 1.0
+	if f.widens() {
+		width = math.Max(scale, 1)
+	}
+	cs := make([]contribution, n)
+	for i := range cs {
+		center := (float64(i)+0.5)*scale - 0.5
+		first := int(math.Ceil(center - f.radius()*width))
+		last := int(math.Floor(center + f.radius()*width))
+		ws := make([]float64, last-first+1)
+		var sum float64
+		for j := range ws {
+			ws[j] = f.weight((float64(first+j) - center) / width)
+			sum += ws[j]
+		}
+		c := contribution{first, make([]float32, len(ws))}
+		for j, w := range ws {
+			c.weights[j] = float32(w / sum)
+		}
+		cs[i] = c
+	}
+	return cs
+}
+
+// Resize returns src resampled to w x h pixels, treating pixels beyond the
+// edges as copies of the nearest edge pixel. The Lanczos filter keeps more
+// detail than the box filter, but rings around hard edges, which may make
+// colors overshoot.
+func Resize(src *FloatImage, w, h int, f ResizeFilter) *FloatImage {
+	// Resample rows first, then columns of the intermediate image.
+	tmp := NewFloatImage(w, src.h)
+	cols := contributions(src.w, w, f)
+	for y := 0; y < src.h; y++ {
+		for x, c := range cols {
+			var sum Vec3
+			for j, wt := range c.weights {
+				sum = vec3add(sum, vec3mulf(src.clampedAt(c.first+j, y), wt))
+			}
+			tmp.Set(x, y, sum)
+		}
+	}
+	dst := NewFloatImage(w, h)
+	rows := contributions(src.h, h, f)
+	for y, c := range rows {
+		for x := 0; x < w; x++ {
+			var sum Vec3
+			for j, wt := range c.weights {
+				sum = vec3add(sum, vec3mulf(tmp.clampedAt(x, c.first+j), wt))
+			}
+			dst.Set(x, y, sum)
+		}
+	}
+	return dst
+}
+
+// Resize returns t resampled to w x h pixels. The filter works on colors in
+// [0, 1], before they are rounded to bytes again. The result is opaque.
+func (t *Texture) Resize(w, h int, f ResizeFilter) *Texture {
+	return Resize(t.FloatImage(), w, h, f).Texture()
+}
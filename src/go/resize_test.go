@@ -0,0 +1,76 @@
+package main
+
+import testing "testing"
+
+// checkerboard returns a w x h image of alternating black and white pixels.
+func checkerboard(w, h int) *FloatImage {
+	im := NewFloatImage(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x+y)%2 == 1 {
+				im.Set(x, y, Vec3{1, 1, 1})
+			}
+		}
+	}
+	return im
+}
+
+func TestResizeCheckerboardToGray(t *testing.T) {
+	src := checkerboard(16, 16)
+	for _, tc := range []struct {
+		name   string
+		filter ResizeFilter
+		margin int // of pixels which see the edge clamping
+	}{
+		{"box", FilterBox, 0},
+		{"lanczos3", FilterLanczos3, 3},
+	} {
+		dst := Resize(src, 8, 8, tc.filter)
+		for y := tc.margin; y < 8-tc.margin; y++ {
+			for x := tc.margin; x < 8-tc.margin; x++ {
+				if c := dst.At(x, y); !approx(c.x, 0.5, 1e-5) || c.x != c.y || c.y != c.z {
+					t.Errorf("%s: pixel %d, %d is %v, want uniform gray", tc.name, x, y, c)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeKeepsConstantImages(t *testing.T) {
+	src := NewFloatImage(13, 7)
+	for i := range src.pix {
+		src.pix[i] = Vec3{0.25, 0.5, 2}
+	}
+	for _, f := range []ResizeFilter{FilterBox, FilterLanczos3, FilterNearest, FilterBilinear} {
+		for _, size := range [][2]int{{5, 3}, {13, 7}, {29, 20}} {
+			dst := Resize(src, size[0], size[1], f)
+			for i, c := range dst.pix {
+				if !approx(c.x, 0.25, 1e-5) || !approx(c.y, 0.5, 1e-5) || !approx(c.z, 2, 1e-5) {
+					t.Fatalf("filter %d to %dx%d: pixel %d is %v", f, size[0], size[1], i, c)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeNearestDoublesExactly(t *testing.T) {
+	src := asymmetricImage(4, 4)
+	dst := Resize(src, 8, 8, FilterNearest)
+	checkFloatMapping(t, "nearest doubling", dst, src, 8, 8, func(x, y int) (int, int, bool) { return x / 2, y / 2, true })
+}
+
+func TestResizeBilinearHalvesToAverages(t *testing.T) {
+	src := asymmetricImage(8, 8)
+	dst := Resize(src, 4, 4, FilterBilinear)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			var sum Vec3
+			for _, d := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				sum = vec3add(sum, src.At(2*x+d[0], 2*y+d[1]))
+			}
+			if got, want := dst.At(x, y), vec3mulf(sum, 0.25); got != want {
+				t.Errorf("pixel %d, %d is %v, want the average %v", x, y, got, want)
+			}
+		}
+	}
+}
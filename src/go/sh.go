@@ -0,0 +1,70 @@
+package main
+
+import math "math"
+import rand "math/rand"
+
+// shBasis returns the 9 real spherical harmonics of bands 0 to 2 in the unit
+// direction d.
+func shBasis(d Vec3) [9]float32 {
+	return [9]float32{
+		0.282095,
+		0.488603 * d.y,
+		0.488603 * d.z,
+		0.488603 * d.x,
+		1.092548 * d.x * d.y,
+		1.092548 * d.y * d.z,
+		0.315392 * (3*d.z*d.z - 1),
+		1.092548 * d.x * d.z,
+		0.546274 * (d.x*d.x - d.y*d.y),
+	}
+}
+
+// shBand is the band of each of the 9 coefficients.
+var shBand = [9]int{0, 1, 1, 1, 2, 2, 2, 2, 2}
+
+// shCosine is the projection of the clamped cosine lobe per band, which turns
+// radiance coefficients into irradiance ones.
+var shCosine = [3]float32{math.Pi, 2 * math.Pi / 3, math.Pi / 4}
+
+// ComputeSHCoefficients projects the radiance env returns for unit directions
+// onto the spherical harmonics of bands 0 to 2, from samples directions
+// uniformly distributed over the sphere. The directions are drawn with a
+// fixed seed, so the coefficients are the same for every call.
+func ComputeSHCoefficients(env func(Vec3) Vec3, samples int) [9]Vec3 {
+	var c [9]Vec3
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < samples; i++ {
+		z := 1 - 2*rng.Float64()
+		sin, cos := math.Sincos(2 * math.Pi * rng.Float64())
+		r := math.Sqrt(1 - z*z)
+		d := Vec3{float32(r * cos), float32(r * sin), float32(z)}
+		l := env(d)
+		for j, y := range shBasis(d) {
+			c[j] = vec3add(c[j], vec3mulf(l, y))
+		}
+	}
+	w := float32(4 * math.Pi / float64(samples))
+	for j := range c {
+		c[j] = vec3mulf(c[j], w)
+	}
+	return c
+}
+
+// EvaluateSH returns the irradiance on a surface with the unit normal n, lit
+// by the environment coeffs were computed from. A white diffuse surface
+// reflects it divided by pi.
+func EvaluateSH(coeffs [9]Vec3, n Vec3) Vec3 {
+	var e Vec3
+	for j, y := range shBasis(n) {
+		e = vec3add(e, vec3mulf(coeffs[j], y*shCosine[shBand[j]]))
+	}
+	return e
+}
+
+// BackgroundEnv returns the colors bg has for rays from the origin, as
+// environment for ComputeSHCoefficients.
+func BackgroundEnv(bg func(*Ray) Vec3) func(Vec3) Vec3 {
+	return func(d Vec3) Vec3 {
+		return bg(&Ray{Vec3{}, d})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import math "math"
+import rand "math/rand"
+import testing "testing"
+
+// directIrradiance integrates the radiance env sends onto a surface with
+// the unit normal n, weighted by the cosine, by Monte Carlo sampling of
+// uniform directions.
+func directIrradiance(env func(Vec3) Vec3, n Vec3, samples int) Vec3 {
+	rng := rand.New(rand.NewSource(2))
+	var sum [3]float64
+	for i := 0; i < samples; i++ {
+		z := 1 - 2*rng.Float64()
+		sin, cos := math.Sincos(2 * math.Pi * rng.Float64())
+		r := math.Sqrt(1 - z*z)
+		d := Vec3{float32(r * cos), float32(r * sin), float32(z)}
+		c := vec3dot(d, n)
+		if c <= 0 {
+			continue
+		}
+		l := env(d)
+		sum[0] += float64(l.x * c)
+		sum[1] += float64(l.y * c)
+		sum[2] += float64(l.z * c)
+	}
+	w := 4 * math.Pi / float64(samples)
+	return Vec3{float32(sum[0] * w), float32(sum[1] * w), float32(sum[2] * w)}
+}
+
+func TestSHIrradianceMatchesIntegration(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		env  func(Vec3) Vec3
+		peak float32 // the brightest radiance of env
+		tol  float32
+	}{
+		{"constant", BackgroundEnv(SolidBackground(Vec3{0.5, 1, 2})), 2, 0.02},
+		{"gradient", BackgroundEnv(GradientBackground(Vec3{1, 0.8, 0.6}, Vec3{0, 0.1, 0.2})), 1, 0.02},
+		// Only the upper hemisphere, which bands 0 to 2 approximate within a
+		// few percent.
+		{"sky", func(d Vec3) Vec3 {
+			v := max(d.y, 0)
+			return Vec3{v, v, v}
+		}, 1, 0.05},
+	} {
+		sh := ComputeSHCoefficients(c.env, 100000)
+		for _, n := range []Vec3{{0, 1, 0}, {0, -1, 0}, {1, 0, 0}, normalize(Vec3{1, 1, -1}), normalize(Vec3{-0.3, -0.5, 2})} {
+			got, want := EvaluateSH(sh, n), directIrradiance(c.env, n, 100000)
+			// Relative to the largest possible irradiance, so dark sides
+			// don't need to match more closely.
+			d := vec3sub(got, want)
+			if e := max(d.x, -d.x, d.y, -d.y, d.z, -d.z) / (math.Pi * c.peak); e > c.tol {
+				t.Errorf("%s: irradiance at %v is %v, integration gives %v", c.name, n, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import fmt "fmt"
+import io "io"
+import math "math"
+
+// nonFiniteColor is what SetV writes for colors with NaN or infinite
+// components, so they stand out in the image.
+var nonFiniteColor = Vec3{1, 0, 1}
+
+// badPixel is a pixel whose color isn't finite.
+type badPixel struct {
+	x, y int
+	v    Vec3
+}
+
+// isFinite reports whether no component of v is NaN or infinite. The
+// components are tested one by one, as their sum may overflow.
+func isFinite(v Vec3) bool {
+	return isFiniteFloat(v.x) && isFiniteFloat(v.y) && isFiniteFloat(v.z)
+}
+
+func isFiniteFloat(f float32) bool {
+	return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
+}
+
+// checkFinite counts the pixel at x, y of the image if v isn't finite, and
+// records it if it comes before any other such pixel in scan order.
+func (ren *Renderer) checkFinite(x, y int, v Vec3) {
+	if isFinite(v) {
+		return
+	}
+	ren.nonFiniteLock.Lock()
+	defer ren.nonFiniteLock.Unlock()
+	ren.nonFiniteCount++
+	if b := ren.nonFinite; b == nil || y < b.y || y == b.y && x < b.x {
+		ren.nonFinite = &badPixel{x, y, v}
+	}
+}
+
+// NonFinite returns the number of pixels whose color wasn't finite in the
+// last render, and the first of them in scan order, if any.
+func (ren *Renderer) NonFinite() (int, *badPixel) {
+	ren.nonFiniteLock.Lock()
+	defer ren.nonFiniteLock.Unlock()
+	return ren.nonFiniteCount, ren.nonFinite
+}
+
+// NonFinite returns the number of pixels of im whose color isn't finite,
+// and the first of them in scan order, if any.
+func (im *FloatImage) NonFinite() (int, *badPixel) {
+	var n int
+	var first *badPixel
+	for i, v := range im.pix {
+		if isFinite(v) {
+			continue
+		}
+		if n++; first == nil {
+			first = &badPixel{i % im.w, i / im.w, v}
+		}
+	}
+	return n, first
+}
+
+// PrintNonFinite writes a summary of the pixels which weren't finite to w,
+// where offset is subtracted from the reported coordinates, and returns
+// whether there were any.
+func (ren *Renderer) PrintNonFinite(w io.Writer, offset int) bool {
+	n, b := ren.NonFinite()
+	return printNonFinite(w, n, b, offset)
+}
+
+func printNonFinite(w io.Writer, n int, b *badPixel, offset int) bool {
+	if n == 0 {
+		return false
+	}
+	fmt.Fprintf(w, "%d non-finite pixels, drawn in magenta, the first at %d,%d: %v\n", n, b.x-offset, b.y-offset, b.v)
+	return true
+}
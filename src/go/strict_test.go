@@ -0,0 +1,91 @@
+package main
+
+import math "math"
+import testing "testing"
+
+func TestIsFinite(t *testing.T) {
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	for _, c := range []struct {
+		v    Vec3
+		want bool
+	}{
+		{Vec3{0, 0, 0}, true},
+		{Vec3{3e38, 3e38, 0}, true},
+		{Vec3{-3e38, 3e38, 3e38}, true},
+		{Vec3{nan, 0, 0}, false},
+		{Vec3{0, inf, 0}, false},
+		{Vec3{0, 0, -inf}, false},
+	} {
+		if got := isFinite(c.v); got != c.want {
+			t.Errorf("isFinite(%v) = %v, want %v", c.v, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeSafe(t *testing.T) {
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	const denormal = 1e-40
+	for _, c := range []struct {
+		v    Vec3
+		want Vec3
+		ok   bool
+	}{
+		{Vec3{0, 0, 0}, Vec3{}, false},
+		{Vec3{0, -0, 0}, Vec3{}, false},
+		{Vec3{3, 0, -4}, Vec3{0.6, 0, -0.8}, true},
+		{Vec3{0, -2, 0}, Vec3{0, -1, 0}, true},
+		// Their squared lengths under- and overflow.
+		{Vec3{denormal, 0, denormal}, Vec3{math.Sqrt2 / 2, 0, math.Sqrt2 / 2}, true},
+		{Vec3{0, 3e38, -3e38}, Vec3{0, math.Sqrt2 / 2, -math.Sqrt2 / 2}, true},
+		{Vec3{inf, 0, 0}, Vec3{}, false},
+		{Vec3{1, -inf, 0}, Vec3{}, false},
+		{Vec3{nan, 1, 0}, Vec3{}, false},
+		{Vec3{0, 0, nan}, Vec3{}, false},
+	} {
+		got, ok := NormalizeSafe(c.v)
+		if ok != c.ok || !approx(got.x, c.want.x, 1e-6) || !approx(got.y, c.want.y, 1e-6) || !approx(got.z, c.want.z, 1e-6) {
+			t.Errorf("NormalizeSafe(%v) = %v, %v, want %v, %v", c.v, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestFloatImageNonFinite(t *testing.T) {
+	im := NewFloatImage(4, 3)
+	im.Set(3, 0, Vec3{3e38, 3e38, 0})
+	im.Set(1, 2, Vec3{0, float32(math.NaN()), 0})
+	im.Set(2, 1, Vec3{float32(math.Inf(-1)), 0, 0})
+	n, b := im.NonFinite()
+	if n != 2 {
+		t.Errorf("counted %d non-finite pixels, want 2", n)
+	}
+	if b == nil || b.x != 2 || b.y != 1 {
+		t.Errorf("first non-finite pixel is %+v, want the one at 2,1", b)
+	}
+}
+
+// nanSphere is a sphere whose surface normals are all NaN.
+type nanSphere struct{ Sphere }
+
+func (s *nanSphere) Intersect(h *Hit, r *Ray) {
+	d := h.distance
+	s.Sphere.Intersect(h, r)
+	if h.distance < d {
+		nan := float32(math.NaN())
+		h.pos = Vec3{nan, nan, nan}
+	}
+}
+
+func TestNonFiniteResetsBetweenRenders(t *testing.T) {
+	ren := pyramidRenderer(16, 12, 1, 1)
+	sphere := ren.scene.g
+	ren.scene.g = &nanSphere{*sphere.(*Sphere)}
+	mustRender(t, ren)
+	if n, _ := ren.NonFinite(); n == 0 {
+		t.Fatal("no non-finite pixels counted for a sphere with NaN normals")
+	}
+	ren.scene.g = sphere
+	mustRender(t, ren)
+	if n, b := ren.NonFinite(); n != 0 || b != nil {
+		t.Errorf("second render reports %d non-finite pixels from the first", n)
+	}
+}
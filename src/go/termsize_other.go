@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import os "os"
+
+const termSizeSupported = false
+
+func termSize(f *os.File) (cols, rows int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package main
+
+import os "os"
+import syscall "syscall"
+import unsafe "unsafe"
+
+const termSizeSupported = true
+
+// termSize returns the size of the terminal f is connected to, if any.
+func termSize(f *os.File) (cols, rows int, ok bool) {
+	var ws struct {
+		row, col, xpixel, ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.col == 0 || ws.row == 0 {
+		return 0, 0, false
+	}
+	return int(ws.col), int(ws.row), true
+}
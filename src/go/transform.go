@@ -0,0 +1,122 @@
+package main
+
+// FlipV reverses the order of the rows of t.
+func (t *Texture) FlipV() {
+	row := 4 * t.w
+	tmp := make([]byte, row)
+	for y := 0; y < t.h/2; y++ {
+		a := t.buf[y*row : (y+1)*row]
+		b := t.buf[(t.h-1-y)*row : (t.h-y)*row]
+		copy(tmp, a)
+		copy(a, b)
+		copy(b, tmp)
+	}
+}
+
+// FlipH reverses the order of the pixels within each row of t.
+func (t *Texture) FlipH() {
+	for y := 0; y < t.h; y++ {
+		reversePixels(t.buf[4*t.w*y : 4*t.w*(y+1)])
+	}
+}
+
+// Rotate180 turns t upside down, which flips it along both axes.
+func (t *Texture) Rotate180() {
+	reversePixels(t.buf)
+}
+
+// Rotate90 turns t by 90 degrees clockwise, swapping its width and height.
+// Unless t is square this needs a copy of its pixels.
+func (t *Texture) Rotate90() {
+	if t.w != t.h {
+		r := NewTexture(t.h, t.w)
+		for y := 0; y < t.h; y++ {
+			for x := 0; x < t.w; x++ {
+				o, ro := t.offset(x, y), r.offset(t.h-1-y, x)
+				copy(r.buf[ro:ro+4], t.buf[o:o+4])
+			}
+		}
+		*t = *r
+		return
+	}
+	// Cycle each pixel of the top-left quadrant through its four rotations.
+	n := t.w
+	for y := 0; y < n/2; y++ {
+		for x := 0; x < (n+1)/2; x++ {
+			a := t.offset(x, y)
+			b := t.offset(n-1-y, x)
+			c := t.offset(n-1-x, n-1-y)
+			d := t.offset(y, n-1-x)
+			for i := 0; i < 4; i++ {
+				t.buf[a+i], t.buf[b+i], t.buf[c+i], t.buf[d+i] = t.buf[d+i], t.buf[a+i], t.buf[b+i], t.buf[c+i]
+			}
+		}
+	}
+}
+
+// reversePixels reverses the order of the 4 byte pixels in buf.
+func reversePixels(buf []byte) {
+	for i, j := 0, len(buf)-4; i < j; i, j = i+4, j-4 {
+		for c := 0; c < 4; c++ {
+			buf[i+c], buf[j+c] = buf[j+c], buf[i+c]
+		}
+	}
+}
+
+// Paste copies src into t with its top-left pixel at x, y. The parts of src
+// which overhang t are left out.
+func (t *Texture) Paste(src *Texture, x, y int) {
+	r, ok := Rect{x, y, x + src.w, y + src.h}.Intersect(Rect{0, 0, t.w, t.h})
+	if !ok {
+		return
+	}
+	for py := r.t; py < r.b; py++ {
+		copy(t.buf[t.offset(r.l, py):t.offset(r.r-1, py)+4], src.buf[src.offset(r.l-x, py-y):])
+	}
+}
+
+// FlipV reverses the order of the rows of im.
+func (im *FloatImage) FlipV() {
+	for y := 0; y < im.h/2; y++ {
+		a := im.pix[y*im.w : (y+1)*im.w]
+		b := im.pix[(im.h-1-y)*im.w : (im.h-y)*im.w]
+		for x := range a {
+			a[x], b[x] = b[x], a[x]
+		}
+	}
+}
+
+// Rotate90 turns im by 90 degrees clockwise, swapping its width and height.
+func (im *FloatImage) Rotate90() {
+	r := NewFloatImage(im.h, im.w)
+	for y := 0; y < im.h; y++ {
+		for x := 0; x < im.w; x++ {
+			r.Set(im.h-1-y, x, im.At(x, y))
+		}
+	}
+	*im = *r
+}
+
+// Crop returns a copy of the part of im inside r, which must lie within im.
+func (im *FloatImage) Crop(r Rect) *FloatImage {
+	c := NewFloatImage(r.Width(), r.Height())
+	if r.isEmpty() {
+		return c
+	}
+	for y := r.t; y < r.b; y++ {
+		copy(c.pix[c.w*(y-r.t):c.w*(y-r.t+1)], im.pix[y*im.w+r.l:y*im.w+r.r])
+	}
+	return c
+}
+
+// Paste copies src into im with its top-left pixel at x, y. The parts of
+// src which overhang im are left out.
+func (im *FloatImage) Paste(src *FloatImage, x, y int) {
+	r, ok := Rect{x, y, x + src.w, y + src.h}.Intersect(Rect{0, 0, im.w, im.h})
+	if !ok {
+		return
+	}
+	for py := r.t; py < r.b; py++ {
+		copy(im.pix[py*im.w+r.l:py*im.w+r.r], src.pix[(py-y)*src.w+r.l-x:])
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import bytes "bytes"
+import testing "testing"
+
+// transformSizes includes odd and even squares, which rotate in place, and
+// rectangles, which don't.
+var transformSizes = [][2]int{{1, 1}, {4, 4}, {5, 5}, {7, 3}, {2, 6}}
+
+func TestTransformsInvertThemselves(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		apply func(*Texture)
+		times int
+	}{
+		{"FlipV", (*Texture).FlipV, 2},
+		{"FlipH", (*Texture).FlipH, 2},
+		{"Rotate180", (*Texture).Rotate180, 2},
+		{"Rotate90", (*Texture).Rotate90, 4},
+	} {
+		for _, size := range transformSizes {
+			want := asymmetricTexture(size[0], size[1])
+			got := want.Copy()
+			for i := 0; i < tc.times; i++ {
+				tc.apply(got)
+			}
+			if got.w != want.w || got.h != want.h || !bytes.Equal(got.buf, want.buf) {
+				t.Errorf("%s %d times changed a %dx%d texture", tc.name, tc.times, size[0], size[1])
+			}
+		}
+	}
+}
+
+func TestRotate180IsBothFlips(t *testing.T) {
+	for _, size := range transformSizes {
+		want := asymmetricTexture(size[0], size[1])
+		want.FlipV()
+		want.FlipH()
+		got := asymmetricTexture(size[0], size[1])
+		got.Rotate180()
+		if !bytes.Equal(got.buf, want.buf) {
+			t.Errorf("Rotate180 of a %dx%d texture isn't FlipV and FlipH", size[0], size[1])
+		}
+	}
+}
+
+// asymmetricImage returns a w x h image whose pixels are all different,
+// holding their own position and index.
+func asymmetricImage(w, h int) *FloatImage {
+	im := NewFloatImage(w, h)
+	for i := range im.pix {
+		im.pix[i] = Vec3{float32(i % w), float32(i / w), float32(i)}
+	}
+	return im
+}
+
+func TestFloatImageTransformsInvertThemselves(t *testing.T) {
+	for _, size := range transformSizes {
+		want := asymmetricImage(size[0], size[1])
+		got := &FloatImage{want.w, want.h, append([]Vec3(nil), want.pix...)}
+		got.FlipV()
+		got.FlipV()
+		for i := 0; i < 4; i++ {
+			got.Rotate90()
+		}
+		if got.w != want.w || got.h != want.h {
+			t.Fatalf("%dx%d image came back as %dx%d", want.w, want.h, got.w, got.h)
+		}
+		for i := range want.pix {
+			if got.pix[i] != want.pix[i] {
+				t.Errorf("FlipV twice and Rotate90 four times changed a %dx%d image", size[0], size[1])
+				break
+			}
+		}
+	}
+}
+
+// checkMapping fails t unless got is w x h and each of its pixels is the
+// pixel of src which from returns for it, or zero where from returns false.
+func checkMapping(t *testing.T, name string, got, src *Texture, w, h int, from func(x, y int) (int, int, bool)) {
+	t.Helper()
+	if got.w != w || got.h != h {
+		t.Fatalf("%s is %dx%d, want %dx%d", name, got.w, got.h, w, h)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, a := got.GetRgba(x, y)
+			var wr, wg, wb, wa byte
+			if sx, sy, ok := from(x, y); ok {
+				wr, wg, wb, wa = src.GetRgba(sx, sy)
+			}
+			if r != wr || g != wg || b != wb || a != wa {
+				t.Fatalf("%s: pixel %d, %d is %d, %d, %d, %d, want %d, %d, %d, %d", name, x, y, r, g, b, a, wr, wg, wb, wa)
+			}
+		}
+	}
+}
+
+// checkFloatMapping is checkMapping for FloatImages.
+func checkFloatMapping(t *testing.T, name string, got, src *FloatImage, w, h int, from func(x, y int) (int, int, bool)) {
+	t.Helper()
+	if got.w != w || got.h != h {
+		t.Fatalf("%s is %dx%d, want %dx%d", name, got.w, got.h, w, h)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var want Vec3
+			if sx, sy, ok := from(x, y); ok {
+				want = src.At(sx, sy)
+			}
+			if c := got.At(x, y); c != want {
+				t.Fatalf("%s: pixel %d, %d is %v, want %v", name, x, y, c, want)
+			}
+		}
+	}
+}
+
+func TestTransformsMovePixels(t *testing.T) {
+	for _, size := range transformSizes {
+		w, h := size[0], size[1]
+		src, fsrc := asymmetricTexture(w, h), asymmetricImage(w, h)
+		flipV := func(x, y int) (int, int, bool) { return x, h - 1 - y, true }
+		// Clockwise, so the left column becomes the top row.
+		rotate90 := func(x, y int) (int, int, bool) { return y, h - 1 - x, true }
+
+		got, fgot := src.Copy(), &FloatImage{w, h, append([]Vec3(nil), fsrc.pix...)}
+		got.FlipV()
+		fgot.FlipV()
+		checkMapping(t, "FlipV", got, src, w, h, flipV)
+		checkFloatMapping(t, "FlipV", fgot, fsrc, w, h, flipV)
+
+		got, fgot = src.Copy(), &FloatImage{w, h, append([]Vec3(nil), fsrc.pix...)}
+		got.Rotate90()
+		fgot.Rotate90()
+		checkMapping(t, "Rotate90", got, src, h, w, rotate90)
+		checkFloatMapping(t, "Rotate90", fgot, fsrc, h, w, rotate90)
+
+		got = src.Copy()
+		got.FlipH()
+		checkMapping(t, "FlipH", got, src, w, h, func(x, y int) (int, int, bool) { return w - 1 - x, y, true })
+	}
+}
+
+func TestCrop(t *testing.T) {
+	src, fsrc := asymmetricTexture(7, 5), asymmetricImage(7, 5)
+	for _, r := range []Rect{{0, 0, 7, 5}, {2, 1, 5, 4}, {6, 4, 7, 5}, {0, 2, 7, 3}} {
+		from := func(x, y int) (int, int, bool) { return r.l + x, r.t + y, true }
+		checkMapping(t, "Crop", src.Crop(r), src, r.Width(), r.Height(), from)
+		checkFloatMapping(t, "Crop", fsrc.Crop(r), fsrc, r.Width(), r.Height(), from)
+	}
+}
+
+func TestCropEmpty(t *testing.T) {
+	src, fsrc := asymmetricTexture(7, 5), asymmetricImage(7, 5)
+	// Without width, also at either edge, without height, and with the
+	// corners swapped.
+	for _, r := range []Rect{{3, 1, 3, 4}, {0, 1, 0, 4}, {7, 0, 7, 5}, {2, 4, 5, 4}, {5, 4, 2, 1}} {
+		if c := src.Crop(r); c.w != r.Width() || c.h != r.Height() {
+			t.Errorf("Crop(%v) is %dx%d, want %dx%d", r, c.w, c.h, r.Width(), r.Height())
+		}
+		if c := fsrc.Crop(r); c.w != r.Width() || c.h != r.Height() {
+			t.Errorf("float Crop(%v) is %dx%d, want %dx%d", r, c.w, c.h, r.Width(), r.Height())
+		}
+	}
+}
+
+func TestPasteClipsAtEdges(t *testing.T) {
+	src, fsrc := asymmetricTexture(3, 2), asymmetricImage(3, 2)
+	// Inside, overhanging each edge, and entirely outside.
+	for _, at := range [][2]int{{1, 1}, {-2, 0}, {0, -1}, {5, 2}, {2, 4}, {-1, -1}, {7, 0}, {-3, 0}, {0, 5}} {
+		px, py := at[0], at[1]
+		from := func(x, y int) (int, int, bool) {
+			sx, sy := x-px, y-py
+			return sx, sy, sx >= 0 && sx < 3 && sy >= 0 && sy < 2
+		}
+		dst, fdst := NewTexture(7, 5), NewFloatImage(7, 5)
+		dst.Paste(src, px, py)
+		fdst.Paste(fsrc, px, py)
+		checkMapping(t, "Paste", dst, src, 7, 5, from)
+		checkFloatMapping(t, "Paste", fdst, fsrc, 7, 5, from)
+	}
+}
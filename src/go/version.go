@@ -0,0 +1,27 @@
+package main
+
+// Version is the version of the renderer's options and outputs. It changes
+// when existing options change their meaning, not when new ones are added;
+// use Capabilities to check for those.
+const Version = "1.0.0"
+
+// Capabilities returns the names of the optional features this build
+// supports, sorted. Features the renderer doesn't have at all, like
+// reflection, refraction or a BVH, are never listed.
+func Capabilities() []string {
+	caps := []string{
+		"adaptive",            // -adaptive sampling
+		"csg",                 // CSG intersections of primitives
+		"denoise",             // -denoise and -bilateral
+		"lod",                 // -lod and -prune
+		"png",                 // PNG outputs and inputs
+		"shadows",             // hard shadows from the directional light
+		"spherical-harmonics", // -sh-ambient
+		"ssaa",                // -ssaa
+		"stream",              // -stream
+	}
+	if termSizeSupported {
+		caps = append(caps, "terminal-size")
+	}
+	return append(caps, "transparency")
+}
@@ -0,0 +1,33 @@
+package main
+
+import slices "slices"
+import strings "strings"
+import testing "testing"
+
+func TestCapabilitiesSorted(t *testing.T) {
+	caps := Capabilities()
+	if !slices.IsSorted(caps) {
+		t.Errorf("capabilities %v aren't sorted", caps)
+	}
+	if c := slices.Compact(slices.Clone(caps)); len(c) != len(caps) {
+		t.Errorf("capabilities %v have duplicates", caps)
+	}
+	for _, want := range []string{"csg", "png", "shadows", "transparency"} {
+		if !slices.Contains(caps, want) {
+			t.Errorf("capabilities %v lack %s", caps, want)
+		}
+	}
+	if slices.Contains(caps, "terminal-size") != termSizeSupported {
+		t.Errorf("capabilities %v disagree with terminal size support %v", caps, termSizeSupported)
+	}
+}
+
+func TestVersionFlagListsCapabilities(t *testing.T) {
+	code, out := runMain(t, t.TempDir(), "-version")
+	if code != 0 {
+		t.Fatalf("-version exited with %d: %s", code, out)
+	}
+	if want := "gotrace " + Version + "\n" + strings.Join(Capabilities(), " ") + "\n"; out != want {
+		t.Errorf("-version printed %q, want %q", out, want)
+	}
+}